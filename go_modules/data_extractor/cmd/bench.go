@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/arko_tech_challenge/go_modules/data_extractor/internal"
+)
+
+const benchTable = "extractor_bench"
+
+var benchSpec = internal.TableSpec{
+	Name:            benchTable,
+	Columns:         []string{"id", "name", "code", "amount"},
+	ConflictMode:    internal.ConflictModeUpdate,
+	ConflictColumns: []string{"id"},
+	UpdateColumns:   []string{"name", "code", "amount"},
+}
+
+type benchRow struct {
+	id     int64
+	name   string
+	code   string
+	amount float64
+}
+
+type benchEncoder struct{}
+
+func (benchEncoder) Encode(ctx context.Context, v benchRow) ([]any, error) {
+	return []any{v.id, v.name, v.code, v.amount}, nil
+}
+
+// benchCommand compares the insert and copy write modes of the Postgres sink
+// on a scratch table: each mode loads the rows into an empty table, then
+// loads them again so every row is an update.
+func benchCommand(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("bench", flag.ExitOnError)
+	common := addCommonFlags(fs)
+	rowCount := fs.Int("rows", 200000, "rows loaded by each mode")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: extractor bench [flags]\n\nCreates and drops the table %s.\n", benchTable)
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if err := common.setupLogging(); err != nil {
+		return err
+	}
+
+	batchSize := *common.batchSize
+	if batchSize <= 0 {
+		batchSize = 5000
+	}
+
+	pool, err := common.pool(ctx)
+	if err != nil {
+		return err
+	}
+	defer pool.Close()
+
+	create := fmt.Sprintf("CREATE TABLE %s (id bigint PRIMARY KEY, name varchar(255), code varchar(8), amount numeric(15,2))", benchTable)
+	if _, err := pool.Exec(ctx, create); err != nil {
+		return fmt.Errorf("%w: error creating %s: %w", internal.ErrSinkWrite, benchTable, err)
+	}
+	defer func() {
+		_, _ = pool.Exec(context.WithoutCancel(ctx), "DROP TABLE "+benchTable)
+	}()
+
+	rows := make([]benchRow, *rowCount)
+	for i := range rows {
+		rows[i] = benchRow{id: int64(i + 1), name: fmt.Sprintf("EMPRESA %d LTDA", i+1), code: fmt.Sprintf("%08d", i), amount: float64(i) * 1.5}
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(w, "mode\tpass\trows\tseconds\trows/s\t")
+	for _, mode := range []internal.WriteMode{internal.WriteModeInsert, internal.WriteModeCopy} {
+		if _, err := pool.Exec(ctx, "TRUNCATE "+benchTable); err != nil {
+			return fmt.Errorf("%w: %w", internal.ErrSinkWrite, err)
+		}
+		sink := internal.NewPostgresRepository[benchRow](pool, benchSpec, benchEncoder{}, internal.PGOptions{
+			TxTimeout: time.Minute,
+			WriteMode: mode,
+		})
+		for _, pass := range []string{"insert", "update"} {
+			start := time.Now()
+			for i := 0; i < len(rows); i += batchSize {
+				if err := sink.WriteBatch(ctx, rows[i:min(i+batchSize, len(rows))]); err != nil {
+					return fmt.Errorf("%s mode: %w", mode, err)
+				}
+			}
+			elapsed := time.Since(start)
+			fmt.Fprintf(w, "%s\t%s\t%d\t%.2f\t%.0f\t\n", mode, pass, len(rows), elapsed.Seconds(), float64(len(rows))/elapsed.Seconds())
+		}
+	}
+	return w.Flush()
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/arko_tech_challenge/go_modules/data_extractor/internal"
+)
+
+// doctorCommand checks everything a run depends on before it starts: the
+// database, the tables of the selected pipelines, their sources and the free
+// disk under the storage path.
+func doctorCommand(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("doctor", flag.ExitOnError)
+	common := addCommonFlags(fs)
+	minFreeGB := fs.Float64("min-free-gb", 5, "free disk required under the storage path, in GB")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: extractor doctor [flags] [pipeline...]")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if err := common.setupLogging(); err != nil {
+		return err
+	}
+
+	catalog, err := loadCatalog(*common.configPath)
+	if err != nil {
+		return err
+	}
+	selected := catalog
+	if fs.NArg() > 0 {
+		if selected, err = selectPipelines(catalog, fs.Args()); err != nil {
+			return err
+		}
+	}
+	settings, err := common.settings()
+	if err != nil {
+		return err
+	}
+
+	var checks []internal.Check
+	database := internal.Check{Name: "database", Target: "DATABASE_URL"}
+	pool, err := common.pool(ctx)
+	if err != nil {
+		database.Err = err
+	} else {
+		defer pool.Close()
+		database.Detail = "connected"
+	}
+	checks = append(checks, database)
+
+	if pool != nil {
+		for _, def := range selected {
+			if def.Table.Name != "" {
+				checks = append(checks, internal.CheckTable(ctx, pool, def.Target(settings))...)
+			}
+			if history, ok := def.Table.HistoryTable(); ok && settings.History {
+				checks = append(checks, internal.CheckTable(ctx, pool, history)...)
+			}
+		}
+	}
+
+	seen := make(map[string]bool)
+	for _, def := range selected {
+		if def.SourceUrls == nil {
+			continue
+		}
+		for _, url := range def.SourceUrls(settings) {
+			if !seen[url] {
+				seen[url] = true
+				checks = append(checks, internal.CheckUrl(ctx, url))
+			}
+		}
+	}
+
+	checks = append(checks, internal.CheckFreeDisk(settings.CompanyStoragePath, uint64(*minFreeGB*1e9)))
+
+	failed := 0
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "STATUS\tCHECK\tTARGET\tDETAIL")
+	for _, check := range checks {
+		status, detail := "PASS", check.Detail
+		if !check.Passed() {
+			failed++
+			// pgconn reports one line per address it tried.
+			status, detail = "FAIL", strings.ReplaceAll(check.Err.Error(), "\n", "; ")
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", status, check.Name, check.Target, detail)
+	}
+	w.Flush()
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d checks failed", failed, len(checks))
+	}
+	return nil
+}
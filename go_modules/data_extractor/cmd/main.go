@@ -2,24 +2,47 @@ package main
 
 import (
 	"context"
+	"errors"
+	"flag"
 	"fmt"
-	"log"
+	"log/slog"
+	"net/http"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
 	"time"
 
+	"github.com/arko_tech_challenge/go_modules/data_extractor/internal"
+	"github.com/arko_tech_challenge/go_modules/data_extractor/internal/admin"
+	"github.com/arko_tech_challenge/go_modules/data_extractor/internal/config"
+	"github.com/arko_tech_challenge/go_modules/data_extractor/internal/logging"
+	"github.com/arko_tech_challenge/go_modules/data_extractor/internal/metrics"
 	"github.com/arko_tech_challenge/go_modules/data_extractor/internal/pipelines"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
+const usage = `Usage: extractor <command> [flags] [args]
+
+Commands:
+  run [flags] <pipeline...>|all   run one or more pipelines, independent ones in parallel
+  serve [flags] [pipeline...]     run pipelines on their schedules until stopped
+  doctor [flags] [pipeline...]    check the database, tables, sources and disk before a run
+  migrate [flags] [pipeline...]   create the missing tables, unique indexes and foreign keys
+  rollback [flags] <pipeline...>  swap back the tables a -swap run replaced
+  list [-config file]             list the available pipelines
+  bench [flags]                   compare the insert and copy write modes on a scratch table
+
+Run "extractor run -h" to see the flags accepted by run.
+`
+
 func getEnv(key string, defaultValue string) string {
 	value, exists := os.LookupEnv(key)
 	if !exists {
-		if defaultValue != "" {
-			return defaultValue
-		}
-		log.Fatalf("Environment variable %s is not set", key)
+		return defaultValue
 	}
 	return value
 }
@@ -27,7 +50,7 @@ func getEnv(key string, defaultValue string) string {
 func newPGPool(ctx context.Context, connString string) (*pgxpool.Pool, error) {
 	cfg, err := pgxpool.ParseConfig(connString)
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse connection string: %w", err)
+		return nil, fmt.Errorf("%w: failed to parse connection string: %w", internal.ErrInvalidConfig, err)
 	}
 	cfg.MinConns = 0
 	cfg.MaxConns = int32(max(4, runtime.NumCPU()*4))
@@ -53,40 +76,599 @@ func newPGPool(ctx context.Context, connString string) (*pgxpool.Pool, error) {
 	return pool, nil
 }
 
+// Exit codes of the run command. Orchestrators can retry 3, 4 and 5, which
+// are network or database availability problems, while 6 and 7 need the
+// source or the schema to be fixed first.
+const (
+	exitFailure           = 1
+	exitInvalidConfig     = 2
+	exitSourceUnavailable = 3
+	exitDownloadFailed    = 4
+	exitSinkWrite         = 5
+	exitSchemaMismatch    = 6
+	exitInvalidData       = 7
+	exitInterrupted       = 130
+)
+
+// exitCode picks the code of the most actionable error class when several
+// pipelines failed for different reasons.
+func exitCode(err error) int {
+	switch {
+	case errors.Is(err, internal.ErrInvalidConfig):
+		return exitInvalidConfig
+	case errors.Is(err, internal.ErrSchemaMismatch):
+		return exitSchemaMismatch
+	case errors.Is(err, internal.ErrInvalidData):
+		return exitInvalidData
+	case errors.Is(err, internal.ErrSinkWrite):
+		return exitSinkWrite
+	case errors.Is(err, internal.ErrDownloadFailed):
+		return exitDownloadFailed
+	case errors.Is(err, internal.ErrSourceUnavailable):
+		return exitSourceUnavailable
+	}
+	return exitFailure
+}
+
 func main() {
-	ctx := context.Background()
-	log.Println("Starting data extraction")
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
 
-	companyZipUrl := getEnv("COMPANY_ZIP_URL", "")
-	companyStoragePath := getEnv("COMPANY_STORAGE_PATH", "data")
-	companyZipPath := filepath.Join(companyStoragePath, "companies.zip")
-	extractPath := filepath.Join(companyStoragePath, "extracted")
-	databaseUrl := getEnv("DATABASE_URL", "")
-	locationUrl := getEnv("LOCATION_API_URL", "")
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-	stateLocationUrl := fmt.Sprintf("%s/estados", locationUrl)
-	cityLocationUrl := fmt.Sprintf("%s/municipios", locationUrl)
-	districtLocationUrl := fmt.Sprintf("%s/distritos", locationUrl)
+	switch os.Args[1] {
+	case "run":
+		err := runCommand(ctx, os.Args[2:])
+		if ctx.Err() != nil {
+			slog.Warn("Run interrupted by signal", "error", err)
+			stop()
+			os.Exit(exitInterrupted)
+		}
+		if err != nil {
+			slog.Error("Run failed", "error", err)
+			os.Exit(exitCode(err))
+		}
+	case "serve":
+		if err := serveCommand(ctx, os.Args[2:]); err != nil {
+			slog.Error("Serve failed", "error", err)
+			os.Exit(exitCode(err))
+		}
+	case "doctor":
+		if err := doctorCommand(ctx, os.Args[2:]); err != nil {
+			slog.Error("Doctor failed", "error", err)
+			os.Exit(exitCode(err))
+		}
+	case "migrate":
+		if err := migrateCommand(ctx, os.Args[2:]); err != nil {
+			slog.Error("Migrate failed", "error", err)
+			os.Exit(exitCode(err))
+		}
+	case "rollback":
+		if err := rollbackCommand(ctx, os.Args[2:]); err != nil {
+			slog.Error("Rollback failed", "error", err)
+			os.Exit(exitCode(err))
+		}
+	case "bench":
+		if err := benchCommand(ctx, os.Args[2:]); err != nil {
+			slog.Error("Bench failed", "error", err)
+			os.Exit(exitCode(err))
+		}
+	case "list":
+		if err := listCommand(os.Args[2:]); err != nil {
+			slog.Error("List failed", "error", err)
+			os.Exit(exitCode(err))
+		}
+	case "help", "-h", "--help":
+		fmt.Print(usage)
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n%s", os.Args[1], usage)
+		os.Exit(2)
+	}
+}
+
+func listCommand(args []string) error {
+	fs := flag.NewFlagSet("list", flag.ExitOnError)
+	configPath := fs.String("config", getEnv("EXTRACTOR_CONFIG", ""), "pipeline config file, YAML or JSON (env EXTRACTOR_CONFIG)")
+	fs.Parse(args)
+
+	catalog, err := loadCatalog(*configPath)
+	if err != nil {
+		return err
+	}
+	for _, def := range catalog {
+		fmt.Printf("%-10s batch=%-5d %s\n", def.Name, def.DefaultBatchSize, def.Description)
+	}
+	return nil
+}
+
+func loadCatalog(configPath string) ([]pipelines.Definition, error) {
+	if configPath == "" {
+		return pipelines.Catalog(nil), nil
+	}
+	builtin := make([]string, len(pipelines.Registry))
+	for i, def := range pipelines.Registry {
+		builtin[i] = def.Name
+	}
+	file, err := config.Load(configPath, builtin)
+	if err != nil {
+		return nil, err
+	}
+	return pipelines.Catalog(file), nil
+}
 
-	pool, err := newPGPool(ctx, databaseUrl)
+func runCommand(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("run", flag.ExitOnError)
+	common := addCommonFlags(fs)
+	planOnly := fs.Bool("plan", false, "print the execution plan and exit")
+	dryRun := fs.Bool("dry-run", false, "read, map and encode everything but only report what would change in Postgres")
+	reportPath := fs.String("report", "", "write a JSON run report to this file")
+	markdownPath := fs.String("report-md", "", "write a Markdown run report to this file")
+	metricsPath := fs.String("metrics-file", "", "write Prometheus metrics to this file for the node_exporter textfile collector")
+	provision := fs.Bool("provision", false, "create the missing tables, unique indexes and foreign keys before running")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: extractor run [flags] <pipeline...>|all")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if err := common.setupLogging(); err != nil {
+		return err
+	}
+
+	catalog, err := loadCatalog(*common.configPath)
 	if err != nil {
-		log.Fatalf("Failed to create pool: %v", err)
+		return err
+	}
+
+	selected, err := selectPipelines(catalog, fs.Args())
+	if err != nil {
+		return err
+	}
+
+	scheduler, err := pipelines.NewScheduler(selected)
+	if err != nil {
+		return err
+	}
+	fmt.Print("Execution plan:\n" + scheduler.Plan())
+	if *planOnly {
+		return nil
+	}
+
+	settings, err := common.settings()
+	if err != nil {
+		return err
+	}
+	if *dryRun {
+		settings.DryRun = internal.NewDiffReport()
+	}
+
+	pool, err := common.pool(ctx)
+	if err != nil {
+		return err
 	}
 	defer pool.Close()
 
-	if err := pipelines.RunStatesPipeline(ctx, pool, stateLocationUrl, 300); err != nil {
-		log.Fatalf("Failed to run states pipeline: %v", err)
+	if *provision {
+		if _, err := provisionTables(ctx, pool, selected, settings, true); err != nil {
+			return err
+		}
+	}
+
+	if settings.DeadLetters, err = common.deadLetters(pool); err != nil {
+		return err
+	}
+	if settings.DeadLetters != nil {
+		defer settings.DeadLetters.Close()
+	}
+
+	startedAt := time.Now()
+	runID := startedAt.Format("20060102T150405")
+	ctx = logging.With(ctx, "run_id", runID)
+	ctx = internal.WithLineage(ctx, internal.Lineage{RunID: runID})
+	slog.InfoContext(ctx, "Starting data extraction", "pipelines", len(selected))
+	results := scheduler.Run(ctx, pool, settings)
+	fmt.Print("Run summary:\n" + pipelines.Summary(results))
+	if settings.DryRun != nil {
+		fmt.Print("Dry run, nothing was written:\n" + settings.DryRun.String())
 	}
-	log.Println("States pipeline completed successfully")
 
-	if err := pipelines.RunCitiesPipeline(ctx, pool, cityLocationUrl, 10); err != nil {
-		log.Fatalf("Failed to run cities pipeline: %v", err)
+	report := pipelines.NewReport(startedAt, settings.DryRun != nil, results)
+	if *reportPath != "" {
+		if err := report.WriteJSON(*reportPath); err != nil {
+			slog.ErrorContext(ctx, "Failed to write report", "path", *reportPath, "error", err)
+		}
+	}
+	if *markdownPath != "" {
+		if err := report.WriteMarkdown(*markdownPath); err != nil {
+			slog.ErrorContext(ctx, "Failed to write report", "path", *markdownPath, "error", err)
+		}
+	}
+	if *metricsPath != "" {
+		if err := metrics.WriteTextfile(*metricsPath); err != nil {
+			slog.ErrorContext(ctx, "Failed to write metrics", "path", *metricsPath, "error", err)
+		}
+	}
+	return pipelines.Failures(results)
+}
+
+func serveCommand(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	common := addCommonFlags(fs)
+	statePath := fs.String("state-file", "", "where loaded releases are remembered (default: <storage-path>/serve-state.json)")
+	provision := fs.Bool("provision", false, "create the missing tables, unique indexes and foreign keys before starting")
+	adminAddr := fs.String("admin-addr", getEnv("EXTRACTOR_ADMIN_ADDR", ""), "listen address of the admin HTTP API, e.g. :8081 (env EXTRACTOR_ADMIN_ADDR, empty disables it)")
+	schedules := map[string]string{}
+	fs.Func("schedule", "override a pipeline schedule, as name=\"cron expression\" (repeatable)", func(value string) error {
+		name, expr, ok := strings.Cut(value, "=")
+		if !ok {
+			return fmt.Errorf("expected name=expression")
+		}
+		schedules[name] = expr
+		return nil
+	})
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: extractor serve [flags] [pipeline...]")
+		fs.PrintDefaults()
 	}
-	log.Println("Cities pipeline completed successfully")
+	fs.Parse(args)
+	if err := common.setupLogging(); err != nil {
+		return err
+	}
+
+	catalog, err := loadCatalog(*common.configPath)
+	if err != nil {
+		return err
+	}
+	for name, expr := range schedules {
+		if _, ok := pipelines.Find(catalog, name); !ok {
+			return fmt.Errorf("%w: -schedule: unknown pipeline %q", internal.ErrInvalidConfig, name)
+		}
+		for i := range catalog {
+			if catalog[i].Name == name {
+				catalog[i].Schedule = expr
+			}
+		}
+	}
+
+	selected := catalog
+	if fs.NArg() > 0 {
+		if selected, err = selectPipelines(catalog, fs.Args()); err != nil {
+			return err
+		}
+	}
+
+	settings, err := common.settings()
+	if err != nil {
+		return err
+	}
+	if *statePath == "" {
+		*statePath = filepath.Join(settings.CompanyStoragePath, "serve-state.json")
+	}
+	pool, err := common.pool(ctx)
+	if err != nil {
+		return err
+	}
+	defer pool.Close()
+
+	if *provision {
+		if _, err := provisionTables(ctx, pool, selected, settings, true); err != nil {
+			return err
+		}
+	}
+
+	if settings.DeadLetters, err = common.deadLetters(pool); err != nil {
+		return err
+	}
+	if settings.DeadLetters != nil {
+		defer settings.DeadLetters.Close()
+	}
+
+	runs := pipelines.NewRuns()
+	daemon, err := pipelines.NewDaemon(selected, settings, *statePath, runs)
+	if err != nil {
+		return err
+	}
+	fmt.Print("Schedules:\n" + daemon.Plan())
+
+	if *adminAddr != "" {
+		server := &http.Server{
+			Addr:              *adminAddr,
+			Handler:           admin.NewServer(ctx, catalog, runs, pool, settings).Handler(),
+			ReadHeaderTimeout: 10 * time.Second,
+		}
+		go func() {
+			slog.Info("Admin API listening", "addr", *adminAddr)
+			if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				slog.Error("Admin API stopped", "error", err)
+			}
+		}()
+		defer func() {
+			shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			_ = server.Shutdown(shutdownCtx)
+		}()
+	}
+
+	err = daemon.Run(ctx, pool)
+	// Runs started through the admin API drain on their own; wait for them
+	// before closing the pool under their feet.
+	runs.Wait()
+	slog.Info("Serve stopped")
+	return err
+}
+
+// commonFlags are the flags shared by run, serve, doctor, migrate, rollback
+// and bench.
+type commonFlags struct {
+	configPath         *string
+	batchSize          *int
+	workers            *int
+	drainTimeout       *time.Duration
+	databaseUrl        *string
+	locationUrl        *string
+	companyZipUrl      *string
+	companyStoragePath *string
+	logLevel           *string
+	logFormat          *string
+	writeMode          *string
+	deadLetterFile     *string
+	deadLetterTable    *string
+	sync               map[string]internal.SyncPolicy
+	syncMaxRemoved     *float64
+	swap               map[string]bool
+	swapMaxShrink      *float64
+	retryAttempts      *int
+	retryBackoff       *time.Duration
+	retryMaxBackoff    *time.Duration
+	bulkLoad           map[string]bool
+	sessionParams      map[string]string
+	history            *bool
+	lineage            *bool
+	exports            map[internal.FileFormat]*exportTarget
+	exportComma        *string
+	exportMaxRows      *int
+	exportBestEffort   *bool
+}
+
+func addCommonFlags(fs *flag.FlagSet) commonFlags {
+	sync := map[string]internal.SyncPolicy{}
+	fs.Func("sync", "make every run of a pipeline a full sync, as name=delete or name=tombstone[:column] (repeatable)", func(value string) error {
+		name, mode, ok := strings.Cut(value, "=")
+		if !ok {
+			return fmt.Errorf("expected name=mode")
+		}
+		mode, column, _ := strings.Cut(mode, ":")
+		policy := internal.SyncPolicy{TombstoneColumn: column}
+		var err error
+		if policy.Mode, err = internal.ParseSyncMode(mode); err != nil || policy.Mode == "" {
+			return fmt.Errorf("expected delete or tombstone, got %q", mode)
+		}
+		sync[name] = policy
+		return nil
+	})
+	swap := map[string]bool{}
+	fs.Func("swap", "load the table of a pipeline into <table>_next and swap it in when the run succeeds, keeping the replaced one as <table>_old (repeatable)", func(name string) error {
+		swap[name] = true
+		return nil
+	})
+	bulkLoad := map[string]bool{}
+	fs.Func("bulk-load", "load the table of a pipeline in bulk: drop its non-unique indexes and disable its triggers during the run, then rebuild and analyze it (repeatable)", func(name string) error {
+		bulkLoad[name] = true
+		return nil
+	})
+	sessionParams := map[string]string{}
+	fs.Func("session-param", "set a Postgres parameter in every write transaction, as name=value, like maintenance_work_mem=1GB (repeatable)", func(value string) error {
+		name, value, ok := strings.Cut(value, "=")
+		if !ok || name == "" {
+			return fmt.Errorf("expected name=value")
+		}
+		sessionParams[name] = value
+		return nil
+	})
+	exports := map[internal.FileFormat]*exportTarget{}
+	fs.Func("export", "also write every pipeline to files under a directory, as format=dir[,policy=required|best_effort][,concurrency=n] with format ndjson, csv or parquet (repeatable)", func(value string) error {
+		target, err := parseExportTarget(value)
+		if err != nil {
+			return err
+		}
+		exports[target.format] = target
+		return nil
+	})
+	return commonFlags{
+		exports:            exports,
+		exportComma:        fs.String("export-comma", ",", "delimiter of -export csv files"),
+		exportMaxRows:      fs.Int("export-max-rows", 1000000, "rows per -export file before a new one is started"),
+		exportBestEffort:   fs.Bool("export-best-effort", false, "log -export failures instead of failing the pipeline, for the exports without a policy"),
+		sync:               sync,
+		swap:               swap,
+		retryAttempts:      fs.Int("retry-attempts", 0, "attempts per batch, the first included, on transient Postgres errors such as deadlocks; 1 disables retries (default: per pipeline, 5)"),
+		retryBackoff:       fs.Duration("retry-backoff", 0, "initial backoff before retrying a batch, doubled on each attempt (default: per pipeline, 200ms)"),
+		retryMaxBackoff:    fs.Duration("retry-max-backoff", 0, "maximum backoff before retrying a batch (default: per pipeline, 10s)"),
+		bulkLoad:           bulkLoad,
+		sessionParams:      sessionParams,
+		swapMaxShrink:      fs.Float64("swap-max-shrink", 10, "keep the live table of a -swap pipeline when the loaded one has more than this percentage fewer rows"),
+		syncMaxRemoved:     fs.Float64("sync-max-removed", 10, "abort a -sync pipeline when more than this percentage of its rows would be removed"),
+		configPath:         fs.String("config", getEnv("EXTRACTOR_CONFIG", ""), "pipeline config file, YAML or JSON (env EXTRACTOR_CONFIG)"),
+		batchSize:          fs.Int("batch-size", 0, "rows per batch (default: per pipeline, see list)"),
+		workers:            fs.Int("workers", 0, "concurrent sink workers per pipeline (default: per pipeline, 5 for built-ins)"),
+		drainTimeout:       fs.Duration("drain-timeout", 30*time.Second, "how long in-flight batches may finish after SIGINT/SIGTERM"),
+		databaseUrl:        fs.String("database-url", getEnv("DATABASE_URL", ""), "Postgres DSN (env DATABASE_URL)"),
+		locationUrl:        fs.String("location-url", getEnv("LOCATION_API_URL", ""), "IBGE localidades base URL (env LOCATION_API_URL)"),
+		companyZipUrl:      fs.String("company-zip-url", getEnv("COMPANY_ZIP_URL", ""), "Receita Empresas zip URL (env COMPANY_ZIP_URL)"),
+		companyStoragePath: fs.String("storage-path", getEnv("COMPANY_STORAGE_PATH", "data"), "download and extract directory (env COMPANY_STORAGE_PATH)"),
+		logLevel:           fs.String("log-level", getEnv("EXTRACTOR_LOG_LEVEL", "info"), "debug, info, warn or error (env EXTRACTOR_LOG_LEVEL)"),
+		logFormat:          fs.String("log-format", getEnv("EXTRACTOR_LOG_FORMAT", "text"), "text or json (env EXTRACTOR_LOG_FORMAT)"),
+		deadLetterFile:     fs.String("dead-letter-file", getEnv("EXTRACTOR_DEAD_LETTER_FILE", ""), "append rows Postgres rejects to this NDJSON file and keep loading (env EXTRACTOR_DEAD_LETTER_FILE)"),
+		deadLetterTable:    fs.String("dead-letter-table", getEnv("EXTRACTOR_DEAD_LETTER_TABLE", ""), "insert rows Postgres rejects into this table and keep loading (env EXTRACTOR_DEAD_LETTER_TABLE)"),
+		history:            fs.Bool("history", getEnv("EXTRACTOR_HISTORY", "") == "true", "copy the versions replaced by an update into the history table of the tables that declare one, such as company_history (env EXTRACTOR_HISTORY)"),
+		lineage:            fs.Bool("lineage", getEnv("EXTRACTOR_LINEAGE", "") == "true", "stamp every row with load_run_id, source_uri, source_line and loaded_at (env EXTRACTOR_LINEAGE)"),
+		writeMode:          fs.String("write-mode", getEnv("EXTRACTOR_WRITE_MODE", ""), "insert or copy, overrides the write mode of every pipeline (env EXTRACTOR_WRITE_MODE)"),
+	}
+}
+
+// exportTarget is an -export flag.
+type exportTarget struct {
+	format      internal.FileFormat
+	dir         string
+	policy      internal.FailurePolicy
+	concurrency int
+}
+
+func parseExportTarget(value string) (*exportTarget, error) {
+	target, options, _ := strings.Cut(value, ",")
+	name, dir, ok := strings.Cut(target, "=")
+	if !ok || dir == "" {
+		return nil, fmt.Errorf("expected format=dir")
+	}
+	format, err := internal.ParseFileFormat(name)
+	if err != nil {
+		return nil, err
+	}
+	export := &exportTarget{format: format, dir: dir}
+	for _, option := range strings.Split(options, ",") {
+		if option == "" {
+			continue
+		}
+		key, value, _ := strings.Cut(option, "=")
+		switch key {
+		case "policy":
+			if export.policy, err = internal.ParseFailurePolicy(value); err != nil || value == "" {
+				return nil, fmt.Errorf("expected policy=required or policy=best_effort, got %q", option)
+			}
+		case "concurrency":
+			if export.concurrency, err = strconv.Atoi(value); err != nil || export.concurrency < 1 {
+				return nil, fmt.Errorf("expected a positive concurrency, got %q", option)
+			}
+		default:
+			return nil, fmt.Errorf("unknown export option %q, expected policy or concurrency", key)
+		}
+	}
+	return export, nil
+}
+
+func (f commonFlags) setupLogging() error {
+	logger, err := logging.New(os.Stderr, *f.logLevel, *f.logFormat)
+	if err != nil {
+		return fmt.Errorf("%w: %w", internal.ErrInvalidConfig, err)
+	}
+	slog.SetDefault(logger)
+	return nil
+}
+
+func (f commonFlags) settings() (pipelines.Settings, error) {
+	var writeMode internal.WriteMode
+	if *f.writeMode != "" {
+		mode, err := internal.ParseWriteMode(*f.writeMode)
+		if err != nil {
+			return pipelines.Settings{}, err
+		}
+		writeMode = mode
+	}
+	comma := []rune(*f.exportComma)
+	if len(comma) != 1 {
+		return pipelines.Settings{}, fmt.Errorf("%w: -export-comma must be a single character", internal.ErrInvalidConfig)
+	}
+	var targets []pipelines.TargetFactory
+	for format, export := range f.exports {
+		policy := export.policy
+		if policy == "" && *f.exportBestEffort {
+			policy = internal.FailureBestEffort
+		}
+		targets = append(targets, pipelines.TargetFactory{
+			Name:        string(format),
+			Policy:      policy,
+			Concurrency: export.concurrency,
+			New: func(pipeline string, spec internal.TableSpec) internal.Sink[any] {
+				return internal.NewFileSink[any](internal.FileSinkOptions{
+					Dir:     filepath.Join(export.dir, pipeline),
+					Format:  format,
+					Comma:   comma[0],
+					MaxRows: *f.exportMaxRows,
+					Columns: spec.Columns,
+				})
+			},
+		})
+	}
+	for name, policy := range f.sync {
+		policy.MaxRemovedPercent = *f.syncMaxRemoved
+		f.sync[name] = policy
+	}
+	return pipelines.Settings{
+		LocationUrl:          *f.locationUrl,
+		CompanyZipUrl:        *f.companyZipUrl,
+		CompanyStoragePath:   *f.companyStoragePath,
+		BatchSize:            *f.batchSize,
+		Workers:              *f.workers,
+		DrainTimeout:         *f.drainTimeout,
+		WriteMode:            writeMode,
+		Sync:                 f.sync,
+		History:              *f.history,
+		Lineage:              *f.lineage,
+		Retry:                internal.RetryPolicy{MaxAttempts: *f.retryAttempts, InitialBackoff: *f.retryBackoff, MaxBackoff: *f.retryMaxBackoff},
+		Swap:                 f.swap,
+		SwapMaxShrinkPercent: *f.swapMaxShrink,
+		BulkLoad:             f.bulkLoad,
+		SessionParams:        f.sessionParams,
+		Targets:              targets,
+	}, nil
+}
+
+func (f commonFlags) pool(ctx context.Context) (*pgxpool.Pool, error) {
+	if *f.databaseUrl == "" {
+		return nil, fmt.Errorf("%w: database URL is not set (use -database-url or DATABASE_URL)", internal.ErrInvalidConfig)
+	}
+	pool, err := newPGPool(ctx, *f.databaseUrl)
+	if errors.Is(err, internal.ErrInvalidConfig) {
+		return nil, err
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to create pool: %w", internal.ErrSinkWrite, err)
+	}
+	if err := metrics.RegisterPool(pool); err != nil {
+		slog.Warn("Failed to export pool metrics", "error", err)
+	}
+	return pool, nil
+}
+
+// deadLetters opens the dead-letter output, nil when none is configured.
+func (f commonFlags) deadLetters(pool *pgxpool.Pool) (internal.DeadLetterWriter, error) {
+	switch {
+	case *f.deadLetterFile != "" && *f.deadLetterTable != "":
+		return nil, fmt.Errorf("%w: -dead-letter-file and -dead-letter-table are exclusive", internal.ErrInvalidConfig)
+	case *f.deadLetterFile != "":
+		return internal.NewNDJSONDeadLetters(*f.deadLetterFile)
+	case *f.deadLetterTable != "":
+		return internal.NewTableDeadLetters(pool, *f.deadLetterTable), nil
+	}
+	return nil, nil
+}
+
+func selectPipelines(catalog []pipelines.Definition, names []string) ([]pipelines.Definition, error) {
+	if len(names) == 0 {
+		return nil, fmt.Errorf("%w: no pipeline given, expected one of: %s or all", internal.ErrInvalidConfig, pipelineNames(catalog))
+	}
+
+	if len(names) == 1 && names[0] == "all" {
+		return catalog, nil
+	}
+
+	selected := make([]pipelines.Definition, 0, len(names))
+	for _, name := range names {
+		def, ok := pipelines.Find(catalog, name)
+		if !ok {
+			return nil, fmt.Errorf("%w: unknown pipeline %q, expected one of: %s or all", internal.ErrInvalidConfig, name, pipelineNames(catalog))
+		}
+		selected = append(selected, def)
+	}
+	return selected, nil
+}
 
-	if err := pipelines.RunDistrictsPipeline(ctx, pool, districtLocationUrl, 30); err != nil {
-		log.Fatalf("Failed to run districts pipeline: %v", err)
+func pipelineNames(catalog []pipelines.Definition) string {
+	names := make([]string, len(catalog))
+	for i, def := range catalog {
+		names[i] = def.Name
 	}
-	log.Println("Districts pipeline completed successfully")
-	pipelines.RunCompaniesPipeline(ctx, pool, companyZipUrl, companyZipPath, extractPath, 5000)
+	return strings.Join(names, ", ")
 }
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+
+	"github.com/arko_tech_challenge/go_modules/data_extractor/internal"
+	"github.com/arko_tech_challenge/go_modules/data_extractor/internal/pipelines"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// migrateCommand creates the tables, unique indexes and foreign keys the
+// selected pipelines write to and are missing from the database.
+func migrateCommand(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("migrate", flag.ExitOnError)
+	common := addCommonFlags(fs)
+	planOnly := fs.Bool("plan", false, "print the statements without executing them")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: extractor migrate [flags] [pipeline...]")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if err := common.setupLogging(); err != nil {
+		return err
+	}
+
+	catalog, err := loadCatalog(*common.configPath)
+	if err != nil {
+		return err
+	}
+	selected := catalog
+	if fs.NArg() > 0 {
+		if selected, err = selectPipelines(catalog, fs.Args()); err != nil {
+			return err
+		}
+	}
+
+	settings, err := common.settings()
+	if err != nil {
+		return err
+	}
+	pool, err := common.pool(ctx)
+	if err != nil {
+		return err
+	}
+	defer pool.Close()
+
+	statements, err := provisionTables(ctx, pool, selected, settings, !*planOnly)
+	for _, statement := range statements {
+		fmt.Println(statement + ";")
+	}
+	if err != nil {
+		return err
+	}
+	if len(statements) == 0 {
+		fmt.Println("-- nothing to do, the schema is up to date")
+	}
+	return nil
+}
+
+// provisionTables provisions the table of every definition that has one, as
+// settings make its sink write it. Foreign keys go in a second pass, so they
+// can point at tables created in the first.
+func provisionTables(ctx context.Context, pool *pgxpool.Pool, defs []pipelines.Definition, settings pipelines.Settings, apply bool) ([]string, error) {
+	var statements []string
+	for _, provision := range []func(context.Context, *pgxpool.Pool, internal.TableSpec, bool) ([]string, error){
+		internal.Provision,
+		internal.ProvisionReferences,
+	} {
+		seen := make(map[string]bool)
+		for _, def := range defs {
+			spec := def.Target(settings)
+			if spec.Name == "" || seen[spec.Name] {
+				continue
+			}
+			seen[spec.Name] = true
+			done, err := provision(ctx, pool, spec, apply)
+			statements = append(statements, done...)
+			if err != nil {
+				return statements, fmt.Errorf("provisioning %s: %w", spec.Name, err)
+			}
+		}
+	}
+	if apply && len(statements) > 0 {
+		slog.InfoContext(ctx, "Provisioned schema", "statements", len(statements))
+	}
+	return statements, nil
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+
+	"github.com/arko_tech_challenge/go_modules/data_extractor/internal"
+)
+
+// rollbackCommand swaps back the tables a -swap run replaced.
+func rollbackCommand(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("rollback", flag.ExitOnError)
+	common := addCommonFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: extractor rollback [flags] <pipeline...>")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if err := common.setupLogging(); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return fmt.Errorf("%w: no pipeline given", internal.ErrInvalidConfig)
+	}
+
+	catalog, err := loadCatalog(*common.configPath)
+	if err != nil {
+		return err
+	}
+	selected, err := selectPipelines(catalog, fs.Args())
+	if err != nil {
+		return err
+	}
+	pool, err := common.pool(ctx)
+	if err != nil {
+		return err
+	}
+	defer pool.Close()
+
+	for _, def := range selected {
+		if def.Table.Name == "" {
+			return fmt.Errorf("%w: pipeline %s has no table", internal.ErrInvalidConfig, def.Name)
+		}
+		if err := internal.Rollback(ctx, pool, def.Table.Name); err != nil {
+			return fmt.Errorf("rolling back %s: %w", def.Name, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,156 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/arko_tech_challenge/go_modules/data_extractor/internal/metrics"
+	"github.com/arko_tech_challenge/go_modules/data_extractor/internal/pipelines"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Server is the HTTP control plane of serve mode:
+//
+//	GET  /runs              runs, newest first, with per-pipeline progress
+//	GET  /runs/{id}         one run
+//	POST /runs              start {"pipelines": ["states", ...]} or ["all"]
+//	POST /runs/{id}/cancel  interrupt a run, in-flight batches are drained
+//	GET  /healthz           the process is up
+//	GET  /readyz            Postgres answers a ping
+//	GET  /metrics           Prometheus metrics
+type Server struct {
+	ctx      context.Context
+	catalog  []pipelines.Definition
+	runs     *pipelines.Runs
+	pool     *pgxpool.Pool
+	settings pipelines.Settings
+	// ready defaults to a pool ping; it is a field so the handler can be
+	// exercised without a database.
+	ready func(ctx context.Context) error
+}
+
+// NewServer starts runs requested over HTTP under ctx, so they are drained
+// on shutdown like scheduled ones rather than cancelled with their request.
+func NewServer(ctx context.Context, catalog []pipelines.Definition, runs *pipelines.Runs, pool *pgxpool.Pool, settings pipelines.Settings) *Server {
+	s := &Server{ctx: ctx, catalog: catalog, runs: runs, pool: pool, settings: settings}
+	if pool != nil {
+		s.ready = pool.Ping
+	}
+	return s
+}
+
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /runs", s.listRuns)
+	mux.HandleFunc("GET /runs/{id}", s.getRun)
+	mux.HandleFunc("POST /runs", s.startRun)
+	mux.HandleFunc("POST /runs/{id}/cancel", s.cancelRun)
+	mux.HandleFunc("GET /healthz", s.healthz)
+	mux.HandleFunc("GET /readyz", s.readyz)
+	mux.Handle("GET /metrics", metrics.Handler())
+	return mux
+}
+
+type startRequest struct {
+	Pipelines []string `json:"pipelines"`
+}
+
+func (s *Server) listRuns(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.runs.List())
+}
+
+func (s *Server) getRun(w http.ResponseWriter, r *http.Request) {
+	run, ok := s.runs.Get(r.PathValue("id"))
+	if !ok {
+		writeError(w, http.StatusNotFound, pipelines.ErrRunNotFound)
+		return
+	}
+	writeJSON(w, http.StatusOK, run)
+}
+
+func (s *Server) startRun(w http.ResponseWriter, r *http.Request) {
+	var req startRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request body: %w", err))
+		return
+	}
+	selected, err := s.selectPipelines(req.Pipelines)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	run, err := s.runs.Start(s.ctx, s.pool, s.settings, selected, "api")
+	if errors.Is(err, pipelines.ErrRunConflict) {
+		writeError(w, http.StatusConflict, err)
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	w.Header().Set("Location", "/runs/"+run.ID)
+	writeJSON(w, http.StatusAccepted, run)
+}
+
+func (s *Server) selectPipelines(names []string) ([]pipelines.Definition, error) {
+	if len(names) == 0 {
+		return nil, fmt.Errorf("pipelines is required")
+	}
+	if len(names) == 1 && names[0] == "all" {
+		return s.catalog, nil
+	}
+	selected := make([]pipelines.Definition, 0, len(names))
+	for _, name := range names {
+		def, ok := pipelines.Find(s.catalog, name)
+		if !ok {
+			return nil, fmt.Errorf("unknown pipeline %q", name)
+		}
+		selected = append(selected, def)
+	}
+	return selected, nil
+}
+
+func (s *Server) cancelRun(w http.ResponseWriter, r *http.Request) {
+	run, err := s.runs.Cancel(r.PathValue("id"))
+	switch {
+	case errors.Is(err, pipelines.ErrRunNotFound):
+		writeError(w, http.StatusNotFound, err)
+	case errors.Is(err, pipelines.ErrRunFinished):
+		writeError(w, http.StatusConflict, err)
+	case err != nil:
+		writeError(w, http.StatusInternalServerError, err)
+	default:
+		writeJSON(w, http.StatusAccepted, run)
+	}
+}
+
+func (s *Server) healthz(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+func (s *Server) readyz(w http.ResponseWriter, r *http.Request) {
+	if s.ready != nil {
+		ctx, cancel := context.WithTimeout(r.Context(), 2*time.Second)
+		defer cancel()
+		if err := s.ready(ctx); err != nil {
+			writeError(w, http.StatusServiceUnavailable, err)
+			return
+		}
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ready"})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
@@ -0,0 +1,187 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/arko_tech_challenge/go_modules/data_extractor/internal/pipelines"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// newTestServer serves a catalog of fake pipelines: fast returns at once and
+// slow runs until its run is canceled.
+func newTestServer(t *testing.T) http.Handler {
+	t.Helper()
+	catalog := []pipelines.Definition{
+		{
+			Name: "fast",
+			Run: func(ctx context.Context, pool *pgxpool.Pool, settings pipelines.Settings) (pipelines.Stats, error) {
+				return pipelines.Stats{}, nil
+			},
+		},
+		{
+			Name: "slow",
+			Run: func(ctx context.Context, pool *pgxpool.Pool, settings pipelines.Settings) (pipelines.Stats, error) {
+				<-ctx.Done()
+				return pipelines.Stats{}, &pipelines.InterruptedError{Cause: context.Cause(ctx)}
+			},
+		},
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	runs := pipelines.NewRuns()
+	t.Cleanup(func() {
+		cancel()
+		runs.Wait()
+	})
+	return NewServer(ctx, catalog, runs, nil, pipelines.Settings{}).Handler()
+}
+
+func do(t *testing.T, h http.Handler, method, path, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+func decode[T any](t *testing.T, rec *httptest.ResponseRecorder) T {
+	t.Helper()
+	var v T
+	if err := json.NewDecoder(rec.Body).Decode(&v); err != nil {
+		t.Fatalf("decoding %q: %v", rec.Body.String(), err)
+	}
+	return v
+}
+
+// waitStatus polls the run until it has status.
+func waitStatus(t *testing.T, h http.Handler, id string, status pipelines.Status) pipelines.RunInfo {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		rec := do(t, h, http.MethodGet, "/runs/"+id, "")
+		if rec.Code != http.StatusOK {
+			t.Fatalf("GET /runs/%s: status %d", id, rec.Code)
+		}
+		run := decode[pipelines.RunInfo](t, rec)
+		if run.Status == status {
+			return run
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("run %s is %s, expected %s", id, run.Status, status)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestHealthz(t *testing.T) {
+	h := newTestServer(t)
+	if rec := do(t, h, http.MethodGet, "/healthz", ""); rec.Code != http.StatusOK {
+		t.Fatalf("status %d, expected 200", rec.Code)
+	}
+}
+
+func TestReadyz(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		ready  func(ctx context.Context) error
+		status int
+	}{
+		{"ready", func(ctx context.Context) error { return nil }, http.StatusOK},
+		{"unreachable", func(ctx context.Context) error { return errors.New("connection refused") }, http.StatusServiceUnavailable},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			s := NewServer(context.Background(), nil, pipelines.NewRuns(), nil, pipelines.Settings{})
+			s.ready = tc.ready
+			if rec := do(t, s.Handler(), http.MethodGet, "/readyz", ""); rec.Code != tc.status {
+				t.Fatalf("status %d, expected %d", rec.Code, tc.status)
+			}
+		})
+	}
+}
+
+func TestStartRun(t *testing.T) {
+	h := newTestServer(t)
+
+	rec := do(t, h, http.MethodPost, "/runs", `{"pipelines": ["fast"]}`)
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("status %d, expected 202: %s", rec.Code, rec.Body)
+	}
+	started := decode[pipelines.RunInfo](t, rec)
+	if location := rec.Header().Get("Location"); location != "/runs/"+started.ID {
+		t.Fatalf("Location %q, expected /runs/%s", location, started.ID)
+	}
+	if started.Trigger != "api" {
+		t.Fatalf("trigger %q, expected api", started.Trigger)
+	}
+
+	run := waitStatus(t, h, started.ID, pipelines.StatusSucceeded)
+	if len(run.Pipelines) != 1 || run.Pipelines[0].Name != "fast" {
+		t.Fatalf("pipelines %+v, expected fast", run.Pipelines)
+	}
+	if run.FinishedAt == nil {
+		t.Fatal("finished run has no finished_at")
+	}
+
+	rec = do(t, h, http.MethodGet, "/runs", "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("GET /runs: status %d", rec.Code)
+	}
+	if runs := decode[[]pipelines.RunInfo](t, rec); len(runs) != 1 || runs[0].ID != started.ID {
+		t.Fatalf("GET /runs: %+v, expected run %s", runs, started.ID)
+	}
+}
+
+func TestStartRunInvalid(t *testing.T) {
+	h := newTestServer(t)
+	for _, body := range []string{
+		`not json`,
+		`{}`,
+		`{"pipelines": ["unknown"]}`,
+	} {
+		if rec := do(t, h, http.MethodPost, "/runs", body); rec.Code != http.StatusBadRequest {
+			t.Errorf("POST /runs %s: status %d, expected 400", body, rec.Code)
+		}
+	}
+}
+
+func TestCancelRun(t *testing.T) {
+	h := newTestServer(t)
+
+	rec := do(t, h, http.MethodPost, "/runs", `{"pipelines": ["slow"]}`)
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("status %d, expected 202: %s", rec.Code, rec.Body)
+	}
+	id := decode[pipelines.RunInfo](t, rec).ID
+
+	if rec := do(t, h, http.MethodPost, "/runs", `{"pipelines": ["all"]}`); rec.Code != http.StatusConflict {
+		t.Fatalf("starting a running pipeline: status %d, expected 409", rec.Code)
+	}
+
+	if rec := do(t, h, http.MethodPost, "/runs/"+id+"/cancel", ""); rec.Code != http.StatusAccepted {
+		t.Fatalf("cancel: status %d, expected 202", rec.Code)
+	}
+	run := waitStatus(t, h, id, pipelines.RunCanceled)
+	for run.FinishedAt == nil {
+		time.Sleep(10 * time.Millisecond)
+		run = waitStatus(t, h, id, pipelines.RunCanceled)
+	}
+	if rec := do(t, h, http.MethodPost, "/runs/"+id+"/cancel", ""); rec.Code != http.StatusConflict {
+		t.Fatalf("canceling a finished run: status %d, expected 409", rec.Code)
+	}
+}
+
+func TestRunNotFound(t *testing.T) {
+	h := newTestServer(t)
+	if rec := do(t, h, http.MethodGet, "/runs/missing", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("GET: status %d, expected 404", rec.Code)
+	}
+	if rec := do(t, h, http.MethodPost, "/runs/missing/cancel", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("cancel: status %d, expected 404", rec.Code)
+	}
+}
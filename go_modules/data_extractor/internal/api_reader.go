@@ -2,8 +2,10 @@ package internal
 
 import (
 	"context"
+	"fmt"
 	"io"
 	"net/http"
+	"sync/atomic"
 
 	"golang.org/x/net/html/charset"
 )
@@ -13,43 +15,27 @@ type APISource[T any] struct {
 	mapResponseFn func([]byte) ([]T, bool, error)
 	cache         []T
 	hasNext       bool
+	err           error
+	bytesRead     atomic.Int64
 }
 
+// NewAPISource fetches the first page eagerly so ItemCount is known. A failed
+// fetch does not abort construction: the error is returned by the first Next.
 func NewAPISource[T any](apiUrl string, mapResponseFn func([]byte) ([]T, bool, error)) Source[T] {
 	source := &APISource[T]{apiUrl: apiUrl, mapResponseFn: mapResponseFn, cache: make([]T, 0), hasNext: true}
 
-	req, err := http.NewRequest("GET", apiUrl, nil)
+	records, hasNext, err := source.fetch(context.Background())
 	if err != nil {
+		source.err = err
 		return source
 	}
-
-	resp, err := (&http.Client{}).Do(req)
-	if err != nil {
-		return source
-	}
-	defer resp.Body.Close()
-
-	utf8Reader, err := charset.NewReader(resp.Body, resp.Header.Get("Content-Type"))
-	if err != nil {
-		return source
-	}
-
-	body, err := io.ReadAll(utf8Reader)
-	if err != nil {
-		return source
-	}
-
-	records, _, err := mapResponseFn(body)
-	if err != nil {
-		return source
-	}
-
+	source.hasNext = hasNext
 	source.cache = append(source.cache, records...)
 	return source
 }
 
 func (s *APISource[T]) HasNext(ctx context.Context) bool {
-	return s.hasNext || len(s.cache) > 0
+	return s.err != nil || s.hasNext || len(s.cache) > 0
 }
 
 func (s *APISource[T]) ItemCount() int {
@@ -57,6 +43,14 @@ func (s *APISource[T]) ItemCount() int {
 }
 
 func (s *APISource[T]) Next(ctx context.Context) (T, error) {
+	var empty T
+	if s.err != nil {
+		err := s.err
+		s.err = nil
+		s.hasNext = false
+		return empty, err
+	}
+
 	if len(s.cache) == 0 {
 		return s.lazyRequest(ctx)
 	}
@@ -69,38 +63,58 @@ func (s *APISource[T]) Next(ctx context.Context) (T, error) {
 
 func (s *APISource[T]) lazyRequest(ctx context.Context) (T, error) {
 	var empty T
+	if !s.hasNext {
+		return empty, io.EOF
+	}
 
-	req, err := http.NewRequestWithContext(ctx, "GET", s.apiUrl, nil)
+	records, hasNext, err := s.fetch(ctx)
 	if err != nil {
 		return empty, err
 	}
+	s.hasNext = hasNext
+
+	if len(records) > 0 {
+		s.cache = append(s.cache, records...)
+	}
+	return s.Next(ctx)
+}
+
+func (s *APISource[T]) fetch(ctx context.Context) ([]T, bool, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", s.apiUrl, nil)
+	if err != nil {
+		return nil, false, fmt.Errorf("%w: %w", ErrInvalidConfig, err)
+	}
 
 	resp, err := (&http.Client{}).Do(req)
 	if err != nil {
-		return empty, err
+		return nil, false, fmt.Errorf("%w: %w", ErrSourceUnavailable, err)
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, false, fmt.Errorf("%w: GET %s returned %s", ErrSourceUnavailable, s.apiUrl, resp.Status)
+	}
+
 	utf8Reader, err := charset.NewReader(resp.Body, resp.Header.Get("Content-Type"))
 	if err != nil {
-		return empty, err
+		return nil, false, fmt.Errorf("%w: %w", ErrSourceUnavailable, err)
 	}
 
 	body, err := io.ReadAll(utf8Reader)
 	if err != nil {
-		return empty, err
+		return nil, false, fmt.Errorf("%w: error reading %s: %w", ErrSourceUnavailable, s.apiUrl, err)
 	}
+	s.bytesRead.Add(int64(len(body)))
 
 	records, hasNext, err := s.mapResponseFn(body)
 	if err != nil {
-		return empty, err
+		return nil, false, fmt.Errorf("%w: %w", ErrSchemaMismatch, err)
 	}
-	s.hasNext = hasNext
+	return records, hasNext, nil
+}
 
-	if len(records) > 0 {
-		s.cache = append(s.cache, records...)
-	}
-	return s.Next(ctx)
+func (s *APISource[T]) BytesRead() int64 {
+	return s.bytesRead.Load()
 }
 
 func (s *APISource[T]) Close() error {
@@ -27,6 +27,16 @@ func (b *FixedSizeBatcher[T]) AddUpsertedBatch() {
 	b.upsertedBatches++
 }
 
+func (b *FixedSizeBatcher[T]) Upserted() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.upsertedBatches
+}
+
+func (b *FixedSizeBatcher[T]) Total() int {
+	return b.totalBatches
+}
+
 func NewFixedSizeBatcher[T any](batchSize int, totalItems int) Batcher[T] {
 	return &FixedSizeBatcher[T]{
 		batchSize:       batchSize,
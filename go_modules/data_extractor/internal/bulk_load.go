@@ -0,0 +1,154 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// bulkLoadTable records the indexes and triggers bulk loads set aside, so a
+// later run restores them when the one that dropped them crashed.
+const bulkLoadTable = "extractor_bulk_load"
+
+// bulkLoad takes the non-unique indexes and the user triggers off a table for
+// the length of a run. Unique indexes stay, ON CONFLICT needs them.
+type bulkLoad struct {
+	pool   *pgxpool.Pool
+	table  string
+	params map[string]string
+}
+
+func newBulkLoad(pool *pgxpool.Pool, table string, params map[string]string) *bulkLoad {
+	return &bulkLoad{pool: pool, table: table, params: params}
+}
+
+// begin drops the indexes and disables the triggers, recording them in
+// bulkLoadTable in the same transaction.
+func (b *bulkLoad) begin(ctx context.Context) error {
+	create := fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+		table_name text NOT NULL,
+		kind text NOT NULL,
+		name text NOT NULL,
+		definition text,
+		PRIMARY KEY (table_name, kind, name))`, bulkLoadTable)
+	if _, err := b.pool.Exec(ctx, create); err != nil {
+		return fmt.Errorf("%w: error creating %s: %w", classifyPGError(err), bulkLoadTable, err)
+	}
+
+	tx, err := b.pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("%w: error starting transaction: %w", classifyPGError(err), err)
+	}
+	defer tx.Rollback(ctx)
+
+	rows, err := tx.Query(ctx, `
+		SELECT quote_ident(n.nspname) || '.' || quote_ident(c.relname), pg_get_indexdef(i.indexrelid)
+		FROM pg_index i
+		JOIN pg_class c ON c.oid = i.indexrelid
+		JOIN pg_namespace n ON n.oid = c.relnamespace
+		WHERE i.indrelid = $1::regclass AND NOT i.indisunique
+			AND NOT EXISTS (SELECT 1 FROM pg_constraint WHERE conindid = i.indexrelid)`, b.table)
+	if err != nil {
+		return fmt.Errorf("%w: error listing indexes of %s: %w", classifyPGError(err), b.table, err)
+	}
+	indexes, err := pgx.CollectRows(rows, pgx.RowToStructByPos[struct{ Name, Definition string }])
+	if err != nil {
+		return fmt.Errorf("%w: error listing indexes of %s: %w", classifyPGError(err), b.table, err)
+	}
+	rows, err = tx.Query(ctx, "SELECT quote_ident(tgname) FROM pg_trigger WHERE tgrelid = $1::regclass AND NOT tgisinternal AND tgenabled <> 'D'", b.table)
+	if err != nil {
+		return fmt.Errorf("%w: error listing triggers of %s: %w", classifyPGError(err), b.table, err)
+	}
+	triggers, err := pgx.CollectRows(rows, pgx.RowTo[string])
+	if err != nil {
+		return fmt.Errorf("%w: error listing triggers of %s: %w", classifyPGError(err), b.table, err)
+	}
+
+	record := fmt.Sprintf("INSERT INTO %s (table_name, kind, name, definition) VALUES ($1, $2, $3, $4) ON CONFLICT DO NOTHING", bulkLoadTable)
+	for _, index := range indexes {
+		if _, err := tx.Exec(ctx, record, b.table, "index", index.Name, index.Definition); err != nil {
+			return fmt.Errorf("%w: error recording index %s: %w", classifyPGError(err), index.Name, err)
+		}
+		if _, err := tx.Exec(ctx, "DROP INDEX "+index.Name); err != nil {
+			return fmt.Errorf("%w: error dropping index %s: %w", classifyPGError(err), index.Name, err)
+		}
+	}
+	for _, trigger := range triggers {
+		if _, err := tx.Exec(ctx, record, b.table, "trigger", trigger, nil); err != nil {
+			return fmt.Errorf("%w: error recording trigger %s: %w", classifyPGError(err), trigger, err)
+		}
+		if _, err := tx.Exec(ctx, fmt.Sprintf("ALTER TABLE %s DISABLE TRIGGER %s", b.table, trigger)); err != nil {
+			return fmt.Errorf("%w: error disabling trigger %s: %w", classifyPGError(err), trigger, err)
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("%w: error committing transaction: %w", classifyPGError(err), err)
+	}
+	slog.InfoContext(ctx, "Bulk load started", "table", b.table, "dropped_indexes", len(indexes), "disabled_triggers", len(triggers))
+	return nil
+}
+
+// end rebuilds the indexes and enables the triggers recorded for the table,
+// then analyzes it.
+func (b *bulkLoad) end(ctx context.Context) error {
+	tx, err := b.pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("%w: error starting transaction: %w", classifyPGError(err), err)
+	}
+	defer tx.Rollback(ctx)
+
+	if err := setLocal(ctx, tx, b.params); err != nil {
+		return err
+	}
+	rows, err := tx.Query(ctx, fmt.Sprintf("SELECT kind, name, COALESCE(definition, '') FROM %s WHERE table_name = $1 ORDER BY kind, name", bulkLoadTable), b.table)
+	if err != nil {
+		return fmt.Errorf("%w: error reading %s: %w", classifyPGError(err), bulkLoadTable, err)
+	}
+	recorded, err := pgx.CollectRows(rows, pgx.RowToStructByPos[struct{ Kind, Name, Definition string }])
+	if err != nil {
+		return fmt.Errorf("%w: error reading %s: %w", classifyPGError(err), bulkLoadTable, err)
+	}
+
+	for _, r := range recorded {
+		statement := fmt.Sprintf("ALTER TABLE %s ENABLE TRIGGER %s", b.table, r.Name)
+		if r.Kind == "index" {
+			statement = strings.Replace(r.Definition, " INDEX ", " INDEX IF NOT EXISTS ", 1)
+		}
+		if _, err := tx.Exec(ctx, statement); err != nil {
+			return fmt.Errorf("%w: error restoring %s %s: %w", classifyPGError(err), r.Kind, r.Name, err)
+		}
+	}
+	if _, err := tx.Exec(ctx, fmt.Sprintf("DELETE FROM %s WHERE table_name = $1", bulkLoadTable), b.table); err != nil {
+		return fmt.Errorf("%w: error clearing %s: %w", classifyPGError(err), bulkLoadTable, err)
+	}
+	if _, err := tx.Exec(ctx, "ANALYZE "+b.table); err != nil {
+		return fmt.Errorf("%w: error analyzing %s: %w", classifyPGError(err), b.table, err)
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("%w: error committing transaction: %w", classifyPGError(err), err)
+	}
+	slog.InfoContext(ctx, "Bulk load ended", "table", b.table, "restored", len(recorded))
+	return nil
+}
+
+// setLocal sets the session parameters params for the rest of tx.
+func setLocal(ctx context.Context, tx pgx.Tx, params map[string]string) error {
+	if len(params) == 0 {
+		return nil
+	}
+	calls := make([]string, 0, len(params))
+	args := make([]any, 0, 2*len(params))
+	for name, value := range params {
+		calls = append(calls, fmt.Sprintf("set_config($%d, $%d, true)", len(args)+1, len(args)+2))
+		args = append(args, name, value)
+	}
+	if _, err := tx.Exec(ctx, "SELECT "+strings.Join(calls, ", "), args...); err != nil {
+		return fmt.Errorf("%w: error setting session parameters: %w", classifyPGError(err), err)
+	}
+	return nil
+}
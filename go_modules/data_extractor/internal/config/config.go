@@ -0,0 +1,345 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/arko_tech_challenge/go_modules/data_extractor/internal"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	SourceAPI = "api"
+	SourceCSV = "csv"
+	SourceZip = "zip"
+)
+
+const (
+	TypeString    = "string"
+	TypeInt       = "int"
+	TypeFloat     = "float"
+	TypeDecimalBR = "decimal_br"
+)
+
+type File struct {
+	Pipelines []Pipeline `json:"pipelines" yaml:"pipelines"`
+}
+
+type Pipeline struct {
+	Name        string   `json:"name" yaml:"name"`
+	Description string   `json:"description" yaml:"description"`
+	DependsOn   []string `json:"depends_on" yaml:"depends_on"`
+	Source      Source   `json:"source" yaml:"source"`
+	Columns     []Column `json:"columns" yaml:"columns"`
+	Table       Table    `json:"table" yaml:"table"`
+	BatchSize   int      `json:"batch_size" yaml:"batch_size"`
+	Workers     int      `json:"workers" yaml:"workers"`
+	TxTimeout   string   `json:"tx_timeout" yaml:"tx_timeout"`
+	Retry       Retry    `json:"retry" yaml:"retry"`
+	// Schedule is a cron expression used by serve.
+	Schedule string `json:"schedule" yaml:"schedule"`
+	// WatchRelease makes serve run a zip pipeline only when a newer
+	// dados_abertos_cnpj/YYYY-MM folder than the last loaded one appears.
+	WatchRelease bool `json:"watch_release" yaml:"watch_release"`
+}
+
+// Retry tunes how a batch is retried after a transient error; zero fields
+// take the defaults of internal.RetryPolicy.
+type Retry struct {
+	MaxAttempts    int    `json:"max_attempts" yaml:"max_attempts"`
+	InitialBackoff string `json:"initial_backoff" yaml:"initial_backoff"`
+	MaxBackoff     string `json:"max_backoff" yaml:"max_backoff"`
+}
+
+type Source struct {
+	Type        string `json:"type" yaml:"type"`
+	Url         string `json:"url" yaml:"url"`
+	Path        string `json:"path" yaml:"path"`
+	StoragePath string `json:"storage_path" yaml:"storage_path"`
+	Member      string `json:"member" yaml:"member"`
+	Comma       string `json:"comma" yaml:"comma"`
+	Header      bool   `json:"header" yaml:"header"`
+}
+
+type Column struct {
+	Name  string `json:"name" yaml:"name"`
+	Field string `json:"field" yaml:"field"`
+	// Index is the position of the column in the records of csv and zip
+	// sources, where it is required.
+	Index      *int       `json:"index" yaml:"index"`
+	Type       string     `json:"type" yaml:"type"`
+	References *Reference `json:"references" yaml:"references"`
+	// SQLType and NotNull are used when the table is provisioned; SQLType
+	// defaults to the Postgres type matching Type.
+	SQLType string `json:"sql_type" yaml:"sql_type"`
+	NotNull bool   `json:"not_null" yaml:"not_null"`
+}
+
+type Reference struct {
+	Table  string `json:"table" yaml:"table"`
+	Column string `json:"column" yaml:"column"`
+}
+
+type Table struct {
+	Name           string `json:"name" yaml:"name"`
+	ConflictMode   string `json:"conflict_mode" yaml:"conflict_mode"`
+	ConflictColumn string `json:"conflict_column" yaml:"conflict_column"`
+	// ConflictColumns is a composite key, exclusive with ConflictColumn.
+	// Entries in parentheses are index expressions, like "(lower(name))".
+	ConflictColumns []string `json:"conflict_columns" yaml:"conflict_columns"`
+	// Duplicates is first_wins (default) or last_wins.
+	Duplicates    string   `json:"duplicates" yaml:"duplicates"`
+	UpdateColumns []string `json:"update_columns" yaml:"update_columns"`
+	// CoalesceColumns keep their stored value when the incoming one is NULL
+	// or empty.
+	CoalesceColumns []string `json:"coalesce_columns" yaml:"coalesce_columns"`
+	// WriteMode is insert (default) or copy, see internal.WriteMode.
+	WriteMode string `json:"write_mode" yaml:"write_mode"`
+	// Sync is delete or tombstone to make every run a full sync of the
+	// table, see internal.SyncPolicy.
+	Sync              string  `json:"sync" yaml:"sync"`
+	TombstoneColumn   string  `json:"tombstone_column" yaml:"tombstone_column"`
+	MaxRemovedPercent float64 `json:"max_removed_percent" yaml:"max_removed_percent"`
+	// History declares a history table, written when history is enabled,
+	// see internal.HistorySpec.
+	History *History `json:"history" yaml:"history"`
+}
+
+type History struct {
+	Table   string   `json:"table" yaml:"table"`
+	Columns []string `json:"columns" yaml:"columns"`
+}
+
+// Load reads a YAML or JSON pipeline file. ${VAR} references are expanded
+// from the environment before parsing. A depends_on entry must name a
+// pipeline of the file or one of builtin.
+func Load(path string, builtin []string) (*File, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("%w: error reading config file: %w", internal.ErrInvalidConfig, err)
+	}
+	expanded := os.ExpandEnv(string(raw))
+
+	var file File
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		err = json.Unmarshal([]byte(expanded), &file)
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal([]byte(expanded), &file)
+	default:
+		return nil, fmt.Errorf("%w: unsupported config extension %q, expected .json, .yaml or .yml", internal.ErrInvalidConfig, filepath.Ext(path))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: error parsing config file %s: %w", internal.ErrInvalidConfig, path, err)
+	}
+
+	seen := make(map[string]bool)
+	for i := range file.Pipelines {
+		p := &file.Pipelines[i]
+		if err := p.Validate(); err != nil {
+			return nil, fmt.Errorf("%w: pipeline %d (%s): %w", internal.ErrInvalidConfig, i, p.Name, err)
+		}
+		if seen[p.Name] {
+			return nil, fmt.Errorf("%w: pipeline %q is declared twice", internal.ErrInvalidConfig, p.Name)
+		}
+		seen[p.Name] = true
+	}
+	for _, p := range file.Pipelines {
+		for _, dep := range p.DependsOn {
+			if !seen[dep] && !slices.Contains(builtin, dep) {
+				return nil, fmt.Errorf("%w: pipeline %q depends on unknown pipeline %q", internal.ErrInvalidConfig, p.Name, dep)
+			}
+		}
+	}
+	return &file, nil
+}
+
+func (p *Pipeline) Validate() error {
+	if p.Name == "" {
+		return fmt.Errorf("name is required")
+	}
+
+	switch p.Source.Type {
+	case SourceAPI, SourceZip:
+		if p.Source.Url == "" {
+			return fmt.Errorf("source.url is required for %s sources", p.Source.Type)
+		}
+	case SourceCSV:
+		if p.Source.Path == "" {
+			return fmt.Errorf("source.path is required for csv sources")
+		}
+	default:
+		return fmt.Errorf("unknown source.type %q, expected api, csv or zip", p.Source.Type)
+	}
+	if p.Source.Comma == "" {
+		p.Source.Comma = ";"
+	}
+	if len([]rune(p.Source.Comma)) != 1 {
+		return fmt.Errorf("source.comma must be a single character")
+	}
+	if p.Source.StoragePath == "" {
+		p.Source.StoragePath = "data"
+	}
+
+	if p.BatchSize <= 0 {
+		p.BatchSize = 1000
+	}
+
+	if len(p.Columns) == 0 {
+		return fmt.Errorf("at least one column is required")
+	}
+	indexes := make(map[int]string)
+	for i := range p.Columns {
+		c := &p.Columns[i]
+		if c.Name == "" {
+			return fmt.Errorf("column %d: name is required", i)
+		}
+		if p.Source.Type == SourceAPI && c.Field == "" {
+			c.Field = c.Name
+		}
+		if p.Source.Type != SourceAPI {
+			switch {
+			case c.Index == nil:
+				return fmt.Errorf("column %s: index is required for %s sources", c.Name, p.Source.Type)
+			case *c.Index < 0:
+				return fmt.Errorf("column %s: index must not be negative", c.Name)
+			case indexes[*c.Index] != "":
+				return fmt.Errorf("column %s: index %d is already used by column %s", c.Name, *c.Index, indexes[*c.Index])
+			}
+			indexes[*c.Index] = c.Name
+		}
+		switch c.Type {
+		case "":
+			c.Type = TypeString
+		case TypeString, TypeInt, TypeFloat, TypeDecimalBR:
+		default:
+			return fmt.Errorf("column %s: unknown type %q", c.Name, c.Type)
+		}
+		if c.References != nil && (c.References.Table == "" || c.References.Column == "") {
+			return fmt.Errorf("column %s: references needs table and column", c.Name)
+		}
+	}
+
+	if p.Table.Name == "" {
+		return fmt.Errorf("table.name is required")
+	}
+	if _, err := internal.ParseConflictMode(p.Table.ConflictMode); err != nil {
+		return err
+	}
+	if p.Table.ConflictColumn != "" && len(p.Table.ConflictColumns) > 0 {
+		return fmt.Errorf("table.conflict_column and table.conflict_columns are exclusive")
+	}
+	if _, err := internal.ParseDuplicatePolicy(p.Table.Duplicates); err != nil {
+		return err
+	}
+	keys := append([]string{p.Table.ConflictColumn}, p.Table.ConflictColumns...)
+	keys = append(keys, p.Table.UpdateColumns...)
+	for _, col := range append(keys, p.Table.CoalesceColumns...) {
+		if col != "" && !strings.HasPrefix(col, "(") && !p.hasColumn(col) {
+			return fmt.Errorf("table column %q is not declared in columns", col)
+		}
+	}
+
+	if _, err := internal.ParseWriteMode(p.Table.WriteMode); err != nil {
+		return err
+	}
+	if _, err := internal.ParseSyncMode(p.Table.Sync); err != nil {
+		return err
+	}
+	if p.Table.Sync != "" && slices.ContainsFunc(p.Table.ConflictColumns, func(col string) bool { return strings.HasPrefix(col, "(") }) {
+		return fmt.Errorf("table.sync needs a conflict key of plain columns")
+	}
+	if p.Table.MaxRemovedPercent < 0 || p.Table.MaxRemovedPercent > 100 {
+		return fmt.Errorf("table.max_removed_percent must be between 0 and 100")
+	}
+	if h := p.Table.History; h != nil {
+		if h.Table == "" || len(h.Columns) == 0 {
+			return fmt.Errorf("table.history needs table and columns")
+		}
+		if mode := internal.ConflictMode(p.Table.ConflictMode); mode != internal.ConflictModeUpdate && mode != internal.ConflictModeUpdateChanged {
+			return fmt.Errorf("table.history needs the update or update_changed conflict mode")
+		}
+		if slices.ContainsFunc(p.Table.ConflictColumns, func(col string) bool { return strings.HasPrefix(col, "(") }) {
+			return fmt.Errorf("table.history needs a conflict key of plain columns")
+		}
+		for _, col := range h.Columns {
+			if !p.hasColumn(col) {
+				return fmt.Errorf("table.history column %q is not declared in columns", col)
+			}
+		}
+	}
+
+	if _, err := p.Timeout(); err != nil {
+		return err
+	}
+	if _, err := p.RetryPolicy(); err != nil {
+		return err
+	}
+	if p.Schedule != "" {
+		if _, err := internal.ParseCron(p.Schedule); err != nil {
+			return err
+		}
+	}
+	if p.WatchRelease && (p.Source.Type != SourceZip || internal.ReleaseOf(p.Source.Url) == "") {
+		return fmt.Errorf("watch_release needs a zip source whose url has a YYYY-MM folder")
+	}
+	return nil
+}
+
+func (p Pipeline) ColumnNames() []string {
+	names := make([]string, len(p.Columns))
+	for i, c := range p.Columns {
+		names[i] = c.Name
+	}
+	return names
+}
+
+func (p Pipeline) Timeout() (time.Duration, error) {
+	if p.TxTimeout == "" {
+		return 10 * time.Second, nil
+	}
+	d, err := time.ParseDuration(p.TxTimeout)
+	if err != nil {
+		return 0, fmt.Errorf("%w: invalid tx_timeout: %w", internal.ErrInvalidConfig, err)
+	}
+	return d, nil
+}
+
+func (p Pipeline) RetryPolicy() (internal.RetryPolicy, error) {
+	policy := internal.RetryPolicy{MaxAttempts: p.Retry.MaxAttempts}
+	if policy.MaxAttempts < 0 {
+		return policy, fmt.Errorf("%w: retry.max_attempts must not be negative", internal.ErrInvalidConfig)
+	}
+	for _, d := range []struct {
+		name  string
+		value string
+		to    *time.Duration
+	}{
+		{"initial_backoff", p.Retry.InitialBackoff, &policy.InitialBackoff},
+		{"max_backoff", p.Retry.MaxBackoff, &policy.MaxBackoff},
+	} {
+		if d.value == "" {
+			continue
+		}
+		parsed, err := time.ParseDuration(d.value)
+		if err != nil {
+			return policy, fmt.Errorf("%w: invalid retry.%s: %w", internal.ErrInvalidConfig, d.name, err)
+		}
+		*d.to = parsed
+	}
+	return policy, nil
+}
+
+func (p Pipeline) hasColumn(name string) bool {
+	for _, c := range p.Columns {
+		if c.Name == name {
+			return true
+		}
+	}
+	return false
+}
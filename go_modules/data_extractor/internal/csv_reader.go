@@ -3,20 +3,36 @@ package internal
 import (
 	"bufio"
 	"encoding/csv"
+	"errors"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
+	"sync/atomic"
 
 	"context"
 )
 
 type CSVSource[T any] struct {
 	reader     *csv.Reader
-	mapFn      func([]string) (T, error)
+	name       string
+	mapFn      func([]string, SourcePosition) (T, error)
 	closer     io.Closer
 	nextRecord []string
+	nextLine   int
 	nextErr    error
 	itemCount  int
+	counter    *countingReader
+}
+
+type countingReader struct {
+	r io.Reader
+	n atomic.Int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n.Add(int64(n))
+	return n, err
 }
 
 func (s *CSVSource[T]) SkipHeader() error {
@@ -24,12 +40,21 @@ func (s *CSVSource[T]) SkipHeader() error {
 	if err != nil {
 		return fmt.Errorf("error skipping header: %w", err)
 	}
-	log.Printf("Header skipped: %v", header)
+	slog.Debug("CSV header skipped", "columns", len(header))
 	return nil
 }
 
-func NewCSVSource[T any](r io.ReadCloser, comma rune, mapFn func([]string) (T, error), itemCount int) Source[T] {
-	bufferedReader := bufio.NewReaderSize(r, 1024*1024)
+func NewCSVSource[T any](r io.ReadCloser, comma rune, header bool, mapFn func([]string) (T, error), itemCount int) Source[T] {
+	return NewPositionedCSVSource(r, "", comma, header, func(record []string, pos SourcePosition) (T, error) {
+		return mapFn(record)
+	}, itemCount)
+}
+
+// NewPositionedCSVSource is NewCSVSource with a mapFn that also gets the file
+// name and line of each record.
+func NewPositionedCSVSource[T any](r io.ReadCloser, name string, comma rune, header bool, mapFn func([]string, SourcePosition) (T, error), itemCount int) Source[T] {
+	counter := &countingReader{r: r}
+	bufferedReader := bufio.NewReaderSize(counter, 1024*1024)
 
 	csvReader := csv.NewReader(bufferedReader)
 	csvReader.Comma = comma
@@ -40,22 +65,41 @@ func NewCSVSource[T any](r io.ReadCloser, comma rune, mapFn func([]string) (T, e
 
 	src := &CSVSource[T]{
 		reader:    csvReader,
+		name:      name,
 		mapFn:     mapFn,
 		closer:    r,
 		itemCount: itemCount,
+		counter:   counter,
 	}
 
-	if err := src.SkipHeader(); err != nil {
-		log.Printf("Error skipping header: %v", err)
-		return src
+	if header {
+		if err := src.SkipHeader(); err != nil {
+			src.nextErr = readError(err)
+			return src
+		}
 	}
 
-	src.nextRecord, src.nextErr = src.reader.Read()
-	if src.nextErr != nil {
-		log.Printf("Error reading first record: %v", src.nextErr)
+	src.readNext()
+	return src
+}
+
+func (s *CSVSource[T]) readNext() {
+	s.nextRecord, s.nextErr = s.reader.Read()
+	s.nextErr = readError(s.nextErr)
+	if s.nextErr == nil {
+		s.nextLine, _ = s.reader.FieldPos(0)
 	}
+}
 
-	return src
+func readError(err error) error {
+	if err == nil || errors.Is(err, io.EOF) {
+		return err
+	}
+	var parseErr *csv.ParseError
+	if errors.As(err, &parseErr) {
+		return fmt.Errorf("%w: %w", ErrInvalidData, err)
+	}
+	return fmt.Errorf("%w: %w", ErrSourceUnavailable, err)
 }
 
 func (s *CSVSource[T]) ItemCount() int {
@@ -63,28 +107,37 @@ func (s *CSVSource[T]) ItemCount() int {
 }
 
 func (s *CSVSource[T]) HasNext(ctx context.Context) bool {
-	return s.nextErr != io.EOF
+	return !errors.Is(s.nextErr, io.EOF)
 }
 
 func (s *CSVSource[T]) Next(ctx context.Context) (T, error) {
 	var zero T
 
-	if s.nextErr != nil && s.nextErr != io.EOF {
+	if s.nextErr != nil {
+		if errors.Is(s.nextErr, io.EOF) {
+			return zero, io.EOF
+		}
 		return zero, s.nextErr
 	}
 
-	record := s.nextRecord
+	// The reader reuses the record slice, so map it before reading ahead.
+	v, err := s.mapFn(s.nextRecord, SourcePosition{File: s.name, Line: s.nextLine})
+	if err != nil && !errors.Is(err, ErrSkipRecord) {
+		err = fmt.Errorf("%w: error mapping record %v: %w", ErrInvalidData, s.nextRecord, err)
+	}
 
-	s.nextRecord, s.nextErr = s.reader.Read()
+	s.readNext()
 
-	v, err := s.mapFn(record)
 	if err != nil {
-		return zero, fmt.Errorf("error mapping record %v: %w", record, err)
+		return zero, err
 	}
-
 	return v, nil
 }
 
+func (s *CSVSource[T]) BytesRead() int64 {
+	return s.counter.n.Load()
+}
+
 func (s *CSVSource[T]) Close() error {
 	return s.closer.Close()
 }
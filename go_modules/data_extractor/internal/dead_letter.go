@@ -0,0 +1,114 @@
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// DeadLetter is a row the sink could not write, isolated by bisecting its
+// batch, with the error Postgres returned for it.
+type DeadLetter struct {
+	Time     time.Time      `json:"time"`
+	Pipeline string         `json:"pipeline,omitempty"`
+	Table    string         `json:"table"`
+	Error    string         `json:"error"`
+	SQLState string         `json:"sqlstate,omitempty"`
+	File     string         `json:"file,omitempty"`
+	Line     int            `json:"line,omitempty"`
+	Row      map[string]any `json:"row"`
+}
+
+type DeadLetterWriter interface {
+	WriteDeadLetter(ctx context.Context, letter DeadLetter) error
+	Close() error
+}
+
+type ndjsonDeadLetters struct {
+	mu   sync.Mutex
+	file *os.File
+	enc  *json.Encoder
+}
+
+// NewNDJSONDeadLetters appends dead letters to path, one JSON object per
+// line.
+func NewNDJSONDeadLetters(path string) (DeadLetterWriter, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("%w: error opening dead-letter file: %w", ErrInvalidConfig, err)
+	}
+	return &ndjsonDeadLetters{file: file, enc: json.NewEncoder(file)}, nil
+}
+
+func (w *ndjsonDeadLetters) WriteDeadLetter(ctx context.Context, letter DeadLetter) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err := w.enc.Encode(letter); err != nil {
+		return fmt.Errorf("%w: error writing dead letter: %w", ErrSinkWrite, err)
+	}
+	return nil
+}
+
+func (w *ndjsonDeadLetters) Close() error {
+	return w.file.Close()
+}
+
+type tableDeadLetters struct {
+	pool  *pgxpool.Pool
+	table string
+
+	once      sync.Once
+	createErr error
+}
+
+// NewTableDeadLetters inserts dead letters into table, which is created on
+// the first write if it does not exist.
+func NewTableDeadLetters(pool *pgxpool.Pool, table string) DeadLetterWriter {
+	return &tableDeadLetters{pool: pool, table: pgx.Identifier(strings.Split(table, ".")).Sanitize()}
+}
+
+func (w *tableDeadLetters) WriteDeadLetter(ctx context.Context, letter DeadLetter) error {
+	w.once.Do(func() {
+		_, err := w.pool.Exec(ctx, fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+	id bigserial PRIMARY KEY,
+	created_at timestamptz NOT NULL,
+	pipeline text,
+	target_table text NOT NULL,
+	error text NOT NULL,
+	sqlstate text,
+	source_file text,
+	source_line integer,
+	row_data jsonb NOT NULL
+)`, w.table))
+		if err != nil {
+			w.createErr = fmt.Errorf("%w: error creating dead-letter table %s: %w", classifyPGError(err), w.table, err)
+		}
+	})
+	if w.createErr != nil {
+		return w.createErr
+	}
+
+	row, err := json.Marshal(letter.Row)
+	if err != nil {
+		return fmt.Errorf("%w: error encoding dead letter: %w", ErrInvalidData, err)
+	}
+	_, err = w.pool.Exec(ctx, fmt.Sprintf(`INSERT INTO %s
+	(created_at, pipeline, target_table, error, sqlstate, source_file, source_line, row_data)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8::jsonb)`, w.table),
+		letter.Time, letter.Pipeline, letter.Table, letter.Error, letter.SQLState, letter.File, letter.Line, string(row))
+	if err != nil {
+		return fmt.Errorf("%w: error writing dead letter: %w", classifyPGError(err), err)
+	}
+	return nil
+}
+
+func (w *tableDeadLetters) Close() error {
+	return nil
+}
@@ -0,0 +1,96 @@
+package internal
+
+import (
+	"fmt"
+	"strings"
+)
+
+// DuplicatePolicy picks which row of a batch is written when several share
+// the same conflict key.
+type DuplicatePolicy string
+
+const (
+	// DuplicatesFirstWins keeps the first row of each key. It is the default.
+	DuplicatesFirstWins DuplicatePolicy = "first_wins"
+	// DuplicatesLastWins keeps the values of the last row of each key, at the
+	// position of the first one.
+	DuplicatesLastWins DuplicatePolicy = "last_wins"
+)
+
+func ParseDuplicatePolicy(s string) (DuplicatePolicy, error) {
+	switch policy := DuplicatePolicy(s); policy {
+	case DuplicatesFirstWins, DuplicatesLastWins:
+		return policy, nil
+	case "":
+		return DuplicatesFirstWins, nil
+	}
+	return "", fmt.Errorf("%w: invalid duplicates policy %q, expected first_wins or last_wins", ErrInvalidConfig, s)
+}
+
+// deduper collects the encoded rows of a batch, one per conflict key. The key
+// of a row is the values of the key columns; when the key has index
+// expressions, rows are only deduplicated through Identifiable. Rows with a
+// null in the key are never duplicates, as in a unique index.
+type deduper struct {
+	spec       TableSpec
+	indexes    []int
+	seen       map[any]int
+	rows       [][]any
+	items      []any
+	duplicates int
+}
+
+func newDeduper(spec TableSpec, size int) *deduper {
+	var indexes []int
+	if len(spec.keyColumns()) == len(spec.conflictKey()) {
+		indexes = spec.keyIndexes()
+	}
+	return &deduper{
+		spec:    spec,
+		indexes: indexes,
+		seen:    make(map[any]int, size),
+		rows:    make([][]any, 0, size),
+		items:   make([]any, 0, size),
+	}
+}
+
+func (d *deduper) add(v any, values []any) {
+	key := d.key(v, values)
+	if key == nil {
+		d.rows = append(d.rows, values)
+		d.items = append(d.items, v)
+		return
+	}
+	if i, ok := d.seen[key]; ok {
+		d.duplicates++
+		if d.spec.Duplicates == DuplicatesLastWins {
+			d.rows[i] = values
+			d.items[i] = v
+		}
+		return
+	}
+	d.seen[key] = len(d.rows)
+	d.rows = append(d.rows, values)
+	d.items = append(d.items, v)
+}
+
+// key returns nil when the row has no key to deduplicate on.
+func (d *deduper) key(v any, values []any) any {
+	switch len(d.indexes) {
+	case 0:
+		if id, ok := v.(Identifiable); ok {
+			return id.ID()
+		}
+		return nil
+	case 1:
+		return values[d.indexes[0]]
+	}
+	var b strings.Builder
+	for _, i := range d.indexes {
+		if values[i] == nil {
+			return nil
+		}
+		fmt.Fprintf(&b, "%T:%v\x1f", values[i], values[i])
+	}
+	return b.String()
+}
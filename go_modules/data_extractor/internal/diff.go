@@ -0,0 +1,271 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type DiffCounts struct {
+	Inserted   int64
+	Updated    int64
+	Unchanged  int64
+	Rejected   int64
+	Duplicates int64
+}
+
+func (c *DiffCounts) add(o DiffCounts) {
+	c.Inserted += o.Inserted
+	c.Updated += o.Updated
+	c.Unchanged += o.Unchanged
+	c.Rejected += o.Rejected
+	c.Duplicates += o.Duplicates
+}
+
+// DiffReport accumulates what a dry run would have done, per table.
+type DiffReport struct {
+	mu     sync.Mutex
+	tables map[string]*DiffCounts
+	order  []string
+}
+
+func NewDiffReport() *DiffReport {
+	return &DiffReport{tables: make(map[string]*DiffCounts)}
+}
+
+func (r *DiffReport) Add(table string, counts DiffCounts) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	c, ok := r.tables[table]
+	if !ok {
+		c = &DiffCounts{}
+		r.tables[table] = c
+		r.order = append(r.order, table)
+	}
+	c.add(counts)
+}
+
+func (r *DiffReport) Counts(table string) DiffCounts {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if c, ok := r.tables[table]; ok {
+		return *c
+	}
+	return DiffCounts{}
+}
+
+func (r *DiffReport) String() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "%-12s %10s %10s %10s %10s %10s\n", "table", "insert", "update", "unchanged", "rejected", "duplicate")
+	for _, table := range r.order {
+		c := r.tables[table]
+		fmt.Fprintf(&b, "%-12s %10d %10d %10d %10d %10d\n", table, c.Inserted, c.Updated, c.Unchanged, c.Rejected, c.Duplicates)
+	}
+	return b.String()
+}
+
+type columnInfo struct {
+	Name      string
+	Type      string
+	NotNull   bool
+	MaxLength int
+}
+
+// DiffSink is a Sink that never writes. Each batch is encoded like the
+// Postgres sink would and compared, in a read-only transaction, against the
+// rows already stored under the same conflict key.
+type DiffSink[T any] struct {
+	pool    *pgxpool.Pool
+	spec    TableSpec
+	encoder DBEncoder[T]
+	report  *DiffReport
+
+	once    sync.Once
+	columns []columnInfo
+	loadErr error
+}
+
+func NewDiffSink[T any](pool *pgxpool.Pool, spec TableSpec, encoder DBEncoder[T], report *DiffReport) Sink[T] {
+	return &DiffSink[T]{
+		pool:    pool,
+		spec:    spec,
+		encoder: encoder,
+		report:  report,
+	}
+}
+
+func (d *DiffSink[T]) WriteBatch(ctx context.Context, batch []T) error {
+	if len(batch) == 0 {
+		return nil
+	}
+
+	d.once.Do(func() {
+		d.columns, d.loadErr = loadColumns(ctx, d.pool, d.spec)
+	})
+	if d.loadErr != nil {
+		return d.loadErr
+	}
+
+	var counts DiffCounts
+	dedup := newDeduper(d.spec, len(batch))
+
+	for _, v := range batch {
+		values, err := d.encoder.Encode(ctx, v)
+		if err != nil || len(values) != len(d.columns) || !d.valid(values) {
+			counts.Rejected++
+			continue
+		}
+		dedup.add(v, values)
+	}
+	counts.Duplicates = int64(dedup.duplicates)
+	rows := dedup.rows
+
+	if len(rows) > 0 {
+		existing, err := d.compare(ctx, rows, &counts)
+		if err != nil {
+			return err
+		}
+		if d.spec.hasConflictClause() {
+			counts.Unchanged += existing
+		} else {
+			counts.Rejected += existing
+		}
+	}
+
+	d.report.Add(d.spec.Name, counts)
+	return nil
+}
+
+func (d *DiffSink[T]) WriteCounts() WriteCounts {
+	c := d.report.Counts(d.spec.Name)
+	return WriteCounts{Inserted: c.Inserted, Updated: c.Updated, Duplicates: c.Duplicates}
+}
+
+func (d *DiffSink[T]) valid(values []any) bool {
+	for i, c := range d.columns {
+		if values[i] == nil {
+			if c.NotNull {
+				return false
+			}
+			continue
+		}
+		if s, ok := values[i].(string); ok && c.MaxLength > 0 && len([]rune(s)) > c.MaxLength {
+			return false
+		}
+	}
+	return true
+}
+
+// compare adds inserts and updates to counts and returns the number of
+// matched rows whose update columns are unchanged. Rows are compared in
+// chunks that stay under the bind parameter limit.
+func (d *DiffSink[T]) compare(ctx context.Context, rows [][]any, counts *DiffCounts) (int64, error) {
+	tx, err := d.pool.BeginTx(ctx, pgx.TxOptions{AccessMode: pgx.ReadOnly})
+	if err != nil {
+		return 0, fmt.Errorf("%w: error starting read-only transaction: %w", ErrSinkWrite, err)
+	}
+	defer tx.Rollback(ctx)
+
+	var unchanged int64
+	for chunk := range slices.Chunk(rows, max(1, maxParameters/len(d.columns))) {
+		n, err := d.compareChunk(ctx, tx, chunk, counts)
+		if err != nil {
+			return 0, err
+		}
+		unchanged += n
+	}
+	return unchanged, nil
+}
+
+func (d *DiffSink[T]) compareChunk(ctx context.Context, tx pgx.Tx, rows [][]any, counts *DiffCounts) (int64, error) {
+	nCols := len(d.columns)
+	names := make([]string, nCols)
+	for i, c := range d.columns {
+		names[i] = c.Name
+	}
+
+	placeholders := make([]string, 0, len(rows))
+	args := make([]any, 0, len(rows)*nCols)
+	for i, values := range rows {
+		slots := make([]string, nCols)
+		for j, c := range d.columns {
+			slots[j] = fmt.Sprintf("$%d::%s", i*nCols+j+1, c.Type)
+		}
+		placeholders = append(placeholders, fmt.Sprintf("(%s)", strings.Join(slots, ", ")))
+		args = append(args, values...)
+	}
+
+	changed := "false"
+	if d.spec.updates() {
+		changed = d.spec.changed("t", "i")
+	}
+
+	// Index expressions are not evaluated here: stored rows are matched on
+	// the plain columns of the key only.
+	join := "false"
+	if keys := d.spec.keyColumns(); len(keys) > 0 {
+		conds := make([]string, len(keys))
+		for i, col := range keys {
+			conds[i] = fmt.Sprintf("t.%s = i.%s", col, col)
+		}
+		join = strings.Join(conds, " AND ")
+	}
+	sql := fmt.Sprintf(`WITH i (%s) AS (VALUES %s)
+SELECT
+	count(*) FILTER (WHERE t.ctid IS NULL),
+	count(*) FILTER (WHERE t.ctid IS NOT NULL AND %s),
+	count(*) FILTER (WHERE t.ctid IS NOT NULL AND NOT (%s))
+FROM i LEFT JOIN %s t ON %s`,
+		strings.Join(names, ", "), strings.Join(placeholders, ", "), changed, changed, d.spec.Name, join)
+
+	var inserted, updated, unchanged int64
+	if err := tx.QueryRow(ctx, sql, args...).Scan(&inserted, &updated, &unchanged); err != nil {
+		return 0, fmt.Errorf("%w: error comparing batch with %s: %w", classifyPGError(err), d.spec.Name, err)
+	}
+
+	counts.Inserted += inserted
+	counts.Updated += updated
+	return unchanged, nil
+}
+
+func loadColumns(ctx context.Context, pool *pgxpool.Pool, spec TableSpec) ([]columnInfo, error) {
+	rows, err := pool.Query(ctx, `
+SELECT a.attname, a.atttypid::regtype::text, a.attnotnull,
+	CASE WHEN a.atttypid IN ('varchar'::regtype, 'bpchar'::regtype) AND a.atttypmod > 4 THEN a.atttypmod - 4 ELSE 0 END
+FROM pg_attribute a
+WHERE a.attrelid = $1::regclass AND a.attnum > 0 AND NOT a.attisdropped`, spec.Name)
+	if err != nil {
+		return nil, fmt.Errorf("%w: error reading columns of %s: %w", classifyPGError(err), spec.Name, err)
+	}
+	defer rows.Close()
+
+	byName := make(map[string]columnInfo)
+	for rows.Next() {
+		var c columnInfo
+		if err := rows.Scan(&c.Name, &c.Type, &c.NotNull, &c.MaxLength); err != nil {
+			return nil, fmt.Errorf("error scanning columns of %s: %w", spec.Name, err)
+		}
+		byName[c.Name] = c
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error reading columns of %s: %w", spec.Name, err)
+	}
+
+	columns := make([]columnInfo, len(spec.Columns))
+	for i, name := range spec.Columns {
+		c, ok := byName[name]
+		if !ok {
+			return nil, fmt.Errorf("%w: column %s does not exist in table %s", ErrSchemaMismatch, name, spec.Name)
+		}
+		columns[i] = c
+	}
+	return columns, nil
+}
@@ -0,0 +1,13 @@
+//go:build linux || darwin
+
+package internal
+
+import "syscall"
+
+func freeDisk(path string) (uint64, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return 0, err
+	}
+	return st.Bavail * uint64(st.Bsize), nil
+}
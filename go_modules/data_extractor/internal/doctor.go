@@ -0,0 +1,311 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Check is one line of the doctor report. Err is nil when the check passed.
+type Check struct {
+	Name   string
+	Target string
+	Detail string
+	Err    error
+}
+
+func (c Check) Passed() bool {
+	return c.Err == nil
+}
+
+// CheckTable compares spec with the live schema: the table, the privileges
+// the sink needs, every column and its type, and the unique index ON CONFLICT
+// relies on.
+func CheckTable(ctx context.Context, pool *pgxpool.Pool, spec TableSpec) []Check {
+	schema, table := "", spec.Name
+	if s, t, ok := strings.Cut(spec.Name, "."); ok {
+		schema, table = s, t
+	}
+
+	exists := Check{Name: "table", Target: spec.Name}
+	var found bool
+	err := pool.QueryRow(ctx, `
+		SELECT EXISTS (
+			SELECT 1 FROM information_schema.tables
+			WHERE table_schema = COALESCE(NULLIF($1, ''), current_schema()) AND table_name = $2
+		)`, schema, table).Scan(&found)
+	switch {
+	case err != nil:
+		exists.Err = fmt.Errorf("%w: %w", classifyPGError(err), err)
+	case !found:
+		exists.Err = fmt.Errorf("%w: table %s does not exist", ErrSchemaMismatch, spec.Name)
+	}
+	if exists.Err != nil {
+		return []Check{exists}
+	}
+
+	checks := []Check{exists, checkPrivileges(ctx, pool, spec)}
+	checks = append(checks, checkColumns(ctx, pool, spec, schema, table)...)
+	if spec.hasConflictClause() {
+		checks = append(checks, checkUniqueIndex(ctx, pool, spec))
+	}
+	return checks
+}
+
+func checkPrivileges(ctx context.Context, pool *pgxpool.Pool, spec TableSpec) Check {
+	check := Check{Name: "privileges", Target: spec.Name}
+	wanted := []string{"SELECT", "INSERT"}
+	if spec.updates() {
+		wanted = append(wanted, "UPDATE")
+	}
+
+	var missing []string
+	for _, privilege := range wanted {
+		var granted bool
+		err := pool.QueryRow(ctx, "SELECT has_table_privilege($1, $2)", spec.Name, privilege).Scan(&granted)
+		if err != nil {
+			check.Err = fmt.Errorf("%w: %w", classifyPGError(err), err)
+			return check
+		}
+		if !granted {
+			missing = append(missing, privilege)
+		}
+	}
+	if len(missing) > 0 {
+		check.Err = fmt.Errorf("%w: missing %s", ErrSinkWrite, strings.Join(missing, ", "))
+		return check
+	}
+	check.Detail = strings.Join(wanted, ", ")
+	return check
+}
+
+type liveColumn struct {
+	dataType  string
+	maxLength *int32
+}
+
+func checkColumns(ctx context.Context, pool *pgxpool.Pool, spec TableSpec, schema, table string) []Check {
+	rows, err := pool.Query(ctx, `
+		SELECT column_name, data_type, character_maximum_length
+		FROM information_schema.columns
+		WHERE table_schema = COALESCE(NULLIF($1, ''), current_schema()) AND table_name = $2`, schema, table)
+	if err != nil {
+		return []Check{{Name: "columns", Target: spec.Name, Err: fmt.Errorf("%w: %w", classifyPGError(err), err)}}
+	}
+	live := make(map[string]liveColumn)
+	for rows.Next() {
+		var name string
+		var col liveColumn
+		if err := rows.Scan(&name, &col.dataType, &col.maxLength); err != nil {
+			rows.Close()
+			return []Check{{Name: "columns", Target: spec.Name, Err: fmt.Errorf("%w: %w", ErrSinkWrite, err)}}
+		}
+		live[name] = col
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return []Check{{Name: "columns", Target: spec.Name, Err: fmt.Errorf("%w: %w", classifyPGError(err), err)}}
+	}
+
+	checks := make([]Check, 0, len(spec.Columns))
+	for i, name := range spec.Columns {
+		check := Check{Name: "column", Target: spec.Name + "." + name}
+		col, ok := live[name]
+		if !ok {
+			check.Err = fmt.Errorf("%w: column does not exist", ErrSchemaMismatch)
+			checks = append(checks, check)
+			continue
+		}
+		check.Detail = col.dataType
+		if col.maxLength != nil {
+			check.Detail = fmt.Sprintf("%s(%d)", col.dataType, *col.maxLength)
+		}
+		if i < len(spec.Types) && spec.Types[i] != "" {
+			check.Err = compatibleType(spec.Types[i], col)
+		}
+		checks = append(checks, check)
+	}
+	return checks
+}
+
+var typeLength = regexp.MustCompile(`^([a-z ]+?)\s*\((\d+)`)
+
+// typeFamilies groups the Postgres types a value encoded for one of them can
+// be loaded into without an explicit cast.
+var typeFamilies = map[string]string{
+	"smallint":                    "integer",
+	"integer":                     "integer",
+	"int":                         "integer",
+	"bigint":                      "integer",
+	"numeric":                     "numeric",
+	"decimal":                     "numeric",
+	"real":                        "numeric",
+	"double precision":            "numeric",
+	"text":                        "text",
+	"varchar":                     "text",
+	"character varying":           "text",
+	"char":                        "text",
+	"character":                   "text",
+	"boolean":                     "boolean",
+	"date":                        "date",
+	"timestamp":                   "timestamp",
+	"timestamp without time zone": "timestamp",
+	"timestamptz":                 "timestamp",
+	"timestamp with time zone":    "timestamp",
+}
+
+// compatibleType reports whether a column of type expected, as written in a
+// TableSpec, can be loaded into col. Numeric types of the same family are
+// accepted either way; a varchar shorter than expected is not.
+func compatibleType(expected string, col liveColumn) error {
+	expected = strings.ToLower(strings.TrimSpace(expected))
+	base, length := expected, 0
+	if m := typeLength.FindStringSubmatch(expected); m != nil {
+		base = m[1]
+		length, _ = strconv.Atoi(m[2])
+	}
+
+	want, ok := typeFamilies[base]
+	if !ok {
+		want = base
+	}
+	got, ok := typeFamilies[col.dataType]
+	if !ok {
+		got = col.dataType
+	}
+	if want != got {
+		return fmt.Errorf("%w: expected %s", ErrSchemaMismatch, expected)
+	}
+	if length > 0 && col.maxLength != nil && int(*col.maxLength) < length {
+		return fmt.Errorf("%w: expected %s, values may not fit", ErrSchemaMismatch, expected)
+	}
+	return nil
+}
+
+func checkUniqueIndex(ctx context.Context, pool *pgxpool.Pool, spec TableSpec) Check {
+	check := Check{Name: "unique index", Target: spec.Name + " (" + strings.Join(spec.conflictKey(), ", ") + ")"}
+	found, err := hasUniqueIndex(ctx, pool, spec)
+	switch {
+	case err != nil:
+		check.Err = err
+	case !found:
+		check.Err = fmt.Errorf("%w: no unique index or constraint, ON CONFLICT will fail", ErrSchemaMismatch)
+	}
+	return check
+}
+
+// hasUniqueIndex reports whether the table of spec has a unique index on its
+// conflict key.
+func hasUniqueIndex(ctx context.Context, pool *pgxpool.Pool, spec TableSpec) (bool, error) {
+	// pg_get_indexdef returns the column name or the expression of each key
+	// column of an index.
+	rows, err := pool.Query(ctx, `
+		SELECT array(SELECT pg_get_indexdef(i.indexrelid, k, true) FROM generate_series(1, i.indnkeyatts) k)
+		FROM pg_index i
+		WHERE i.indrelid = $1::regclass AND i.indisunique AND i.indpred IS NULL`, spec.Name)
+	if err != nil {
+		return false, fmt.Errorf("%w: %w", classifyPGError(err), err)
+	}
+	defer rows.Close()
+
+	// Expressions are matched by count only, Postgres prints them normalized.
+	key := spec.conflictKey()
+	plain := spec.keyColumns()
+	for rows.Next() {
+		var defs []string
+		if err := rows.Scan(&defs); err != nil {
+			return false, fmt.Errorf("%w: %w", ErrSinkWrite, err)
+		}
+		matched := len(defs) == len(key)
+		for _, col := range plain {
+			matched = matched && slices.Contains(defs, col)
+		}
+		if matched {
+			return true, nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return false, fmt.Errorf("%w: %w", classifyPGError(err), err)
+	}
+	return false, nil
+}
+
+// CheckUrl sends a HEAD request to url. Servers that do not implement HEAD
+// get a GET whose body is not read.
+func CheckUrl(ctx context.Context, url string) Check {
+	check := Check{Name: "http", Target: url}
+	if url == "" {
+		check.Err = fmt.Errorf("%w: URL is not set", ErrInvalidConfig)
+		return check
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 15*time.Second)
+	defer cancel()
+
+	var resp *http.Response
+	for _, method := range []string{http.MethodHead, http.MethodGet} {
+		req, err := http.NewRequestWithContext(ctx, method, url, nil)
+		if err != nil {
+			check.Err = fmt.Errorf("%w: %w", ErrInvalidConfig, err)
+			return check
+		}
+		resp, err = http.DefaultClient.Do(req)
+		if err != nil {
+			check.Err = fmt.Errorf("%w: %w", ErrSourceUnavailable, err)
+			return check
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusMethodNotAllowed && resp.StatusCode != http.StatusNotImplemented {
+			break
+		}
+	}
+
+	check.Detail = resp.Status
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		check.Err = fmt.Errorf("%w: %s", ErrSourceUnavailable, resp.Status)
+	}
+	return check
+}
+
+// CheckFreeDisk fails when the filesystem holding path has less than
+// minBytes available. path does not need to exist yet; its nearest existing
+// parent is used.
+func CheckFreeDisk(path string, minBytes uint64) Check {
+	check := Check{Name: "free disk", Target: path}
+	dir, err := filepath.Abs(path)
+	if err != nil {
+		check.Err = fmt.Errorf("%w: %w", ErrInvalidConfig, err)
+		return check
+	}
+	for {
+		if _, err := os.Stat(dir); err == nil || filepath.Dir(dir) == dir {
+			break
+		}
+		dir = filepath.Dir(dir)
+	}
+
+	free, err := freeDisk(dir)
+	if errors.Is(err, errors.ErrUnsupported) {
+		check.Detail = "not supported on this platform"
+		return check
+	}
+	if err != nil {
+		check.Err = fmt.Errorf("%w: %w", ErrDownloadFailed, err)
+		return check
+	}
+	check.Detail = fmt.Sprintf("%.1f GB free", float64(free)/1e9)
+	if free < minBytes {
+		check.Err = fmt.Errorf("%w: %.1f GB free, %.1f GB required", ErrDownloadFailed, float64(free)/1e9, float64(minBytes)/1e9)
+	}
+	return check
+}
@@ -0,0 +1,14 @@
+package internal
+
+import "errors"
+
+// Error classes returned by sources, sinks and pipelines. They are wrapped
+// together with the underlying error, so use errors.Is to classify a failure.
+var (
+	ErrInvalidConfig     = errors.New("invalid configuration")
+	ErrSourceUnavailable = errors.New("source unavailable")
+	ErrDownloadFailed    = errors.New("download failed")
+	ErrSchemaMismatch    = errors.New("schema mismatch")
+	ErrInvalidData       = errors.New("invalid data")
+	ErrSinkWrite         = errors.New("sink write failed")
+)
@@ -0,0 +1,317 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+
+	"github.com/arko_tech_challenge/go_modules/data_extractor/internal/metrics"
+)
+
+// FailurePolicy is what a FanOut does when one of its targets fails.
+type FailurePolicy string
+
+const (
+	// FailureRequired fails the batch, and so the pipeline. It is the
+	// default.
+	FailureRequired FailurePolicy = "required"
+	// FailureBestEffort logs and counts the failure and moves on.
+	FailureBestEffort FailurePolicy = "best_effort"
+)
+
+func ParseFailurePolicy(s string) (FailurePolicy, error) {
+	switch policy := FailurePolicy(s); policy {
+	case FailureRequired, FailureBestEffort:
+		return policy, nil
+	case "":
+		return FailureRequired, nil
+	}
+	return "", fmt.Errorf("%w: invalid failure policy %q, expected required or best_effort", ErrInvalidConfig, s)
+}
+
+// Target is one of the sinks of a FanOut.
+type Target[T any] struct {
+	// Name labels the target in logs and metrics.
+	Name   string
+	Sink   Sink[T]
+	Policy FailurePolicy
+	// Concurrency is how many workers of its own write batches to Sink.
+	// Zero leaves a required target to the pipeline workers and gives a
+	// best-effort one a single worker.
+	Concurrency int
+}
+
+// bestEffortQueue is how many batches a best-effort target may fall behind
+// before the next ones are dropped.
+const bestEffortQueue = 8
+
+var errBatchesDropped = errors.New("batches dropped while the target was behind")
+
+// FanOut writes every batch to all its targets, so one pass over a source
+// feeds several systems. Targets with a Concurrency, and best-effort ones,
+// have a queue and workers of their own. A batch is acknowledged once every
+// required target wrote it: a best-effort target that falls behind drops
+// batches instead of holding up the pipeline, and is then not finished.
+// WriteCounts are those of the first target that counts writes, usually
+// Postgres.
+type FanOut[T any] struct {
+	targets  []Target[T]
+	queues   []chan fanOutBatch[T]
+	dropped  []atomic.Bool
+	workers  sync.WaitGroup
+	start    sync.Once
+	stop     sync.Once
+	ended    atomic.Bool
+	pipeline string
+}
+
+type fanOutBatch[T any] struct {
+	ctx   context.Context
+	batch []T
+	// done receives the result for required targets; best-effort ones
+	// report their failures themselves.
+	done chan error
+}
+
+func NewFanOutSink[T any](pipeline string, targets ...Target[T]) Sink[T] {
+	f := &FanOut[T]{
+		targets:  targets,
+		queues:   make([]chan fanOutBatch[T], len(targets)),
+		dropped:  make([]atomic.Bool, len(targets)),
+		pipeline: pipeline,
+	}
+	for i, target := range targets {
+		if target.Policy == "" {
+			f.targets[i].Policy = FailureRequired
+		}
+		switch {
+		case f.targets[i].Policy == FailureBestEffort:
+			f.queues[i] = make(chan fanOutBatch[T], bestEffortQueue)
+		case target.Concurrency > 0:
+			f.queues[i] = make(chan fanOutBatch[T])
+		}
+	}
+	return f
+}
+
+func (f *FanOut[T]) WriteBatch(ctx context.Context, batch []T) error {
+	f.start.Do(f.startWorkers)
+	errs := make([]error, len(f.targets))
+	var wg sync.WaitGroup
+	for i, target := range f.targets {
+		if target.Policy == FailureBestEffort {
+			f.enqueue(ctx, i, batch)
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if f.queues[i] == nil {
+				errs[i] = target.Sink.WriteBatch(ctx, batch)
+				return
+			}
+			done := make(chan error, 1)
+			select {
+			case f.queues[i] <- fanOutBatch[T]{ctx: ctx, batch: batch, done: done}:
+				errs[i] = <-done
+			case <-ctx.Done():
+				errs[i] = ctx.Err()
+			}
+		}()
+	}
+	wg.Wait()
+	return f.join(ctx, "write", errs)
+}
+
+// enqueue hands batch to best-effort target i, dropping it when the target
+// is too far behind. Once a batch is dropped the export is incomplete, so
+// the target gets no more.
+func (f *FanOut[T]) enqueue(ctx context.Context, i int, batch []T) {
+	if f.dropped[i].Load() {
+		return
+	}
+	select {
+	case f.queues[i] <- fanOutBatch[T]{ctx: ctx, batch: batch}:
+	default:
+		f.dropped[i].Store(true)
+		f.failed(ctx, "write", i, fmt.Errorf("target %s: %w", f.targets[i].Name, errBatchesDropped))
+	}
+}
+
+func (f *FanOut[T]) startWorkers() {
+	for i, target := range f.targets {
+		if f.queues[i] == nil {
+			continue
+		}
+		for range max(target.Concurrency, 1) {
+			f.workers.Add(1)
+			go func() {
+				defer f.workers.Done()
+				for b := range f.queues[i] {
+					if b.done != nil {
+						b.done <- target.Sink.WriteBatch(b.ctx, b.batch)
+						continue
+					}
+					// The batches left when a run ends early are not written.
+					if f.ended.Load() {
+						continue
+					}
+					if err := target.Sink.WriteBatch(b.ctx, b.batch); err != nil {
+						f.failed(b.ctx, "write", i, fmt.Errorf("target %s: %w", target.Name, err))
+					}
+				}
+			}()
+		}
+	}
+}
+
+// stopWorkers waits for the workers to write what is queued and exit.
+func (f *FanOut[T]) stopWorkers() {
+	f.stop.Do(func() {
+		for _, queue := range f.queues {
+			if queue != nil {
+				close(queue)
+			}
+		}
+		f.workers.Wait()
+	})
+}
+
+// Begin begins the targets that are Beginners, see Beginner.
+func (f *FanOut[T]) Begin(ctx context.Context) error {
+	return f.each(ctx, "begin", func(target Target[T]) error {
+		if beginner, ok := target.Sink.(Beginner); ok {
+			return beginner.Begin(ctx)
+		}
+		return nil
+	})
+}
+
+// End ends the targets that are Enders, see Ender, once their workers are
+// stopped.
+func (f *FanOut[T]) End(ctx context.Context, err error) error {
+	f.ended.Store(true)
+	f.stopWorkers()
+	return f.each(ctx, "end", func(target Target[T]) error {
+		if ender, ok := target.Sink.(Ender); ok {
+			return ender.End(ctx, err)
+		}
+		return nil
+	})
+}
+
+// Finish finishes the targets that are Finishers, see Finisher, one after
+// the other: the required ones in order, then the best-effort ones once
+// they caught up, stopping at the first required failure. An export after
+// Postgres so only writes its manifest once Postgres finished its full sync
+// or swap. A best-effort target that dropped batches is not finished.
+func (f *FanOut[T]) Finish(ctx context.Context) error {
+	for _, policy := range []FailurePolicy{FailureRequired, FailureBestEffort} {
+		if policy == FailureBestEffort {
+			f.stopWorkers()
+		}
+		for i, target := range f.targets {
+			finisher, ok := target.Sink.(Finisher)
+			if !ok || target.Policy != policy || f.dropped[i].Load() {
+				continue
+			}
+			if err := finisher.Finish(ctx); err != nil {
+				if err := f.failed(ctx, "finish", i, fmt.Errorf("target %s: %w", target.Name, err)); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func (f *FanOut[T]) each(ctx context.Context, op string, fn func(Target[T]) error) error {
+	errs := make([]error, len(f.targets))
+	var wg sync.WaitGroup
+	for i, target := range f.targets {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = fn(target)
+		}()
+	}
+	wg.Wait()
+	return f.join(ctx, op, errs)
+}
+
+// join returns the errors of the required targets among errs, indexed like
+// the targets.
+func (f *FanOut[T]) join(ctx context.Context, op string, errs []error) error {
+	var required []error
+	for i, err := range errs {
+		if err != nil {
+			if err := f.failed(ctx, op, i, fmt.Errorf("target %s: %w", f.targets[i].Name, err)); err != nil {
+				required = append(required, err)
+			}
+		}
+	}
+	return errors.Join(required...)
+}
+
+// failed counts the failure of target i and returns err if the target is
+// required.
+func (f *FanOut[T]) failed(ctx context.Context, op string, i int, err error) error {
+	target := f.targets[i]
+	metrics.TargetErrors.WithLabelValues(f.pipeline, target.Name).Inc()
+	if target.Policy == FailureBestEffort {
+		slog.WarnContext(ctx, "Best-effort target failed", "target", target.Name, "op", op, "error", err)
+		return nil
+	}
+	return err
+}
+
+func (f *FanOut[T]) WriteCounts() WriteCounts {
+	for _, target := range f.targets {
+		if counter, ok := target.Sink.(WriteCounter); ok {
+			return counter.WriteCounts()
+		}
+	}
+	return WriteCounts{}
+}
+
+// AnySink adapts a Sink of any value, such as a file export that inspects
+// rows by reflection, to a pipeline of T.
+func AnySink[T any](sink Sink[any]) Sink[T] {
+	return anySink[T]{sink: sink}
+}
+
+type anySink[T any] struct {
+	sink Sink[any]
+}
+
+func (s anySink[T]) WriteBatch(ctx context.Context, batch []T) error {
+	rows := make([]any, len(batch))
+	for i, v := range batch {
+		rows[i] = v
+	}
+	return s.sink.WriteBatch(ctx, rows)
+}
+
+func (s anySink[T]) Begin(ctx context.Context) error {
+	if beginner, ok := s.sink.(Beginner); ok {
+		return beginner.Begin(ctx)
+	}
+	return nil
+}
+
+func (s anySink[T]) End(ctx context.Context, err error) error {
+	if ender, ok := s.sink.(Ender); ok {
+		return ender.End(ctx, err)
+	}
+	return nil
+}
+
+func (s anySink[T]) Finish(ctx context.Context) error {
+	if finisher, ok := s.sink.(Finisher); ok {
+		return finisher.Finish(ctx)
+	}
+	return nil
+}
@@ -0,0 +1,566 @@
+package internal
+
+import (
+	"bufio"
+	"cmp"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/xitongsys/parquet-go/parquet"
+	"github.com/xitongsys/parquet-go/writer"
+)
+
+// FileFormat is the format of the files a FileSink writes.
+type FileFormat string
+
+const (
+	// FileFormatNDJSON writes one JSON object per row, gzipped.
+	FileFormatNDJSON FileFormat = "ndjson"
+	// FileFormatCSV writes a header and one record per row, gzipped.
+	FileFormatCSV FileFormat = "csv"
+	// FileFormatParquet writes Parquet with snappy compressed pages.
+	FileFormatParquet FileFormat = "parquet"
+)
+
+func ParseFileFormat(s string) (FileFormat, error) {
+	switch format := FileFormat(s); format {
+	case FileFormatNDJSON, FileFormatCSV, FileFormatParquet:
+		return format, nil
+	}
+	return "", fmt.Errorf("%w: invalid file format %q, expected ndjson, csv or parquet", ErrInvalidConfig, s)
+}
+
+func (f FileFormat) extension() string {
+	if f == FileFormatParquet {
+		return ".parquet"
+	}
+	return "." + string(f) + ".gz"
+}
+
+type FileSinkOptions struct {
+	// Dir receives a directory per run, named after the run id of the
+	// Lineage of the first batch or its start time, with the files and
+	// manifest.json.
+	Dir    string
+	Format FileFormat
+	// Comma is the CSV delimiter, ',' when zero.
+	Comma rune
+	// MaxRows starts a new file after that many rows, 1000000 when zero.
+	MaxRows int
+	// Columns name the values of rows that are slices, like declarative
+	// pipeline rows; struct rows are named by their csv or json tags.
+	Columns []string
+}
+
+func (o FileSinkOptions) Default() FileSinkOptions {
+	if o.Comma == 0 {
+		o.Comma = ','
+	}
+	if o.MaxRows <= 0 {
+		o.MaxRows = 1000000
+	}
+	return o
+}
+
+// Manifest describes the files of a run of a FileSink. It is written last,
+// so an export without one is incomplete.
+type Manifest struct {
+	Format    FileFormat       `json:"format"`
+	RunID     string           `json:"run_id,omitempty"`
+	Columns   []ManifestColumn `json:"columns"`
+	Rows      int64            `json:"rows"`
+	Files     []ManifestFile   `json:"files"`
+	CreatedAt time.Time        `json:"created_at"`
+}
+
+type ManifestColumn struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
+
+type ManifestFile struct {
+	Name   string `json:"name"`
+	Rows   int64  `json:"rows"`
+	Bytes  int64  `json:"bytes"`
+	SHA256 string `json:"sha256"`
+}
+
+// FileSink writes batches to rotating local files. Rows are written in the
+// order batches arrive, one batch at a time.
+type FileSink[T any] struct {
+	options FileSinkOptions
+
+	mu       sync.Mutex
+	dir      string
+	fields   []fileField
+	current  *exportFile
+	manifest Manifest
+	// failed is the first write error; a manifest would claim rows that
+	// were never written.
+	failed error
+	// done is set once the manifest is written.
+	done bool
+}
+
+func NewFileSink[T any](options FileSinkOptions) *FileSink[T] {
+	return &FileSink[T]{options: options.Default()}
+}
+
+func (s *FileSink[T]) WriteBatch(ctx context.Context, batch []T) error {
+	if len(batch) == 0 {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.writeBatch(ctx, batch); err != nil {
+		s.failed = cmp.Or(s.failed, err)
+		return err
+	}
+	return nil
+}
+
+func (s *FileSink[T]) writeBatch(ctx context.Context, batch []T) error {
+	if s.dir == "" {
+		if err := s.start(ctx, batch[0]); err != nil {
+			return err
+		}
+	}
+	for _, v := range batch {
+		if s.current == nil {
+			if err := s.open(); err != nil {
+				return err
+			}
+		}
+		values, err := s.values(v)
+		if err != nil {
+			return err
+		}
+		if err := s.current.writer.write(values); err != nil {
+			return fmt.Errorf("%w: error writing %s: %w", ErrSinkWrite, s.current.name, err)
+		}
+		s.current.rows++
+		if s.current.rows >= int64(s.options.MaxRows) {
+			if err := s.closeCurrent(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Finish closes the last file and writes the manifest.
+func (s *FileSink[T]) Finish(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.dir == "" {
+		return nil
+	}
+	if s.failed != nil {
+		return fmt.Errorf("%w: export is missing rows after a failed batch: %w", ErrSinkWrite, s.failed)
+	}
+	if err := s.closeCurrent(); err != nil {
+		return err
+	}
+	s.manifest.CreatedAt = time.Now().UTC()
+	raw, err := json.MarshalIndent(s.manifest, "", "  ")
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrSinkWrite, err)
+	}
+	if err := os.WriteFile(filepath.Join(s.dir, "manifest.json"), append(raw, '\n'), 0o644); err != nil {
+		return fmt.Errorf("%w: error writing manifest: %w", ErrSinkWrite, err)
+	}
+	s.done = true
+	return nil
+}
+
+// End closes the file being written and removes the files of the run when
+// no manifest was written, because the run or the export failed: they are
+// an incomplete export.
+func (s *FileSink[T]) End(ctx context.Context, err error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.done || s.dir == "" {
+		return nil
+	}
+	err = cmp.Or(err, s.failed)
+	closeErr := s.closeCurrent()
+	if err := os.RemoveAll(s.dir); err != nil {
+		return fmt.Errorf("%w: error removing incomplete export %s: %w", ErrSinkWrite, s.dir, err)
+	}
+	slog.WarnContext(ctx, "Removed incomplete export", "dir", s.dir, "error", err)
+	s.dir, s.current, s.manifest, s.failed = "", nil, Manifest{}, nil
+	return closeErr
+}
+
+func (s *FileSink[T]) start(ctx context.Context, first T) error {
+	fields, err := fileFields(first, s.options.Columns)
+	if err != nil {
+		return err
+	}
+	s.fields = fields
+
+	run := LineageFrom(ctx).RunID
+	name := run
+	if name == "" {
+		name = time.Now().UTC().Format("20060102T150405")
+	}
+	dir := filepath.Join(s.options.Dir, name)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return fmt.Errorf("%w: error creating %s: %w", ErrSinkWrite, dir, err)
+	}
+	s.dir = dir
+	s.manifest = Manifest{Format: s.options.Format, RunID: run}
+	for _, f := range fields {
+		s.manifest.Columns = append(s.manifest.Columns, ManifestColumn{Name: f.name, Type: f.kind.String()})
+	}
+	return nil
+}
+
+func (s *FileSink[T]) open() error {
+	name := fmt.Sprintf("part-%05d%s", len(s.manifest.Files)+1, s.options.Format.extension())
+	file, err := os.Create(filepath.Join(s.dir, name))
+	if err != nil {
+		return fmt.Errorf("%w: error creating export file: %w", ErrSinkWrite, err)
+	}
+	out := &exportFile{name: name, file: file, hash: sha256.New()}
+	out.counter = &countingWriter{w: io.MultiWriter(file, out.hash)}
+
+	switch s.options.Format {
+	case FileFormatParquet:
+		out.writer, err = newParquetWriter(out.counter, s.fields)
+	case FileFormatCSV:
+		out.writer, err = newCSVWriter(out.counter, s.fields, s.options.Comma)
+	default:
+		out.writer = newNDJSONWriter(out.counter, s.fields)
+	}
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("%w: error starting %s: %w", ErrSinkWrite, name, err)
+	}
+	s.current = out
+	return nil
+}
+
+func (s *FileSink[T]) closeCurrent() error {
+	out := s.current
+	if out == nil {
+		return nil
+	}
+	s.current = nil
+	err := out.writer.close()
+	if closeErr := out.file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("%w: error closing %s: %w", ErrSinkWrite, out.name, err)
+	}
+	s.manifest.Files = append(s.manifest.Files, ManifestFile{
+		Name:   out.name,
+		Rows:   out.rows,
+		Bytes:  out.counter.n,
+		SHA256: hex.EncodeToString(out.hash.Sum(nil)),
+	})
+	s.manifest.Rows += out.rows
+	return nil
+}
+
+// values are the values of the fields of v, nil for nil pointers and
+// missing slice entries.
+func (s *FileSink[T]) values(v T) ([]any, error) {
+	rv := reflect.ValueOf(any(v))
+	for rv.Kind() == reflect.Pointer || rv.Kind() == reflect.Interface {
+		rv = rv.Elem()
+	}
+	values := make([]any, len(s.fields))
+	for i, f := range s.fields {
+		var fv reflect.Value
+		switch {
+		case rv.Kind() == reflect.Struct && f.index != nil:
+			fv = rv.FieldByIndex(f.index)
+		case rv.Kind() == reflect.Slice && f.pos < rv.Len():
+			fv = rv.Index(f.pos)
+		case rv.Kind() == reflect.Slice:
+			continue
+		default:
+			return nil, fmt.Errorf("%w: file sink got a %s after a row of another type", ErrInvalidData, rv.Type())
+		}
+		values[i] = f.convert(fv)
+	}
+	return values, nil
+}
+
+type exportFile struct {
+	name    string
+	file    *os.File
+	hash    hash.Hash
+	counter *countingWriter
+	writer  rowWriter
+	rows    int64
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// fileKind is the column type of a file field, from its Go kind.
+type fileKind int
+
+const (
+	kindString fileKind = iota
+	kindInt
+	kindFloat
+	kindBool
+	kindTimestamp
+)
+
+func (k fileKind) String() string {
+	return [...]string{"string", "int", "float", "bool", "timestamp"}[k]
+}
+
+var timeType = reflect.TypeFor[time.Time]()
+
+type fileField struct {
+	name  string
+	kind  fileKind
+	index []int
+	pos   int
+}
+
+// fileFields derives the fields of a file from the first row: the exported
+// fields of a struct, or columns for a slice, typed after its values.
+func fileFields(first any, columns []string) ([]fileField, error) {
+	rv := reflect.ValueOf(first)
+	for rv.Kind() == reflect.Pointer || rv.Kind() == reflect.Interface {
+		rv = rv.Elem()
+	}
+	var fields []fileField
+	switch rv.Kind() {
+	case reflect.Struct:
+		for _, sf := range reflect.VisibleFields(rv.Type()) {
+			name := sf.Name
+			if tag := sf.Tag.Get("csv"); tag != "" {
+				name = tag
+			} else if tag, _, _ := strings.Cut(sf.Tag.Get("json"), ","); tag != "" {
+				name = tag
+			}
+			if !sf.IsExported() || sf.Anonymous || name == "-" || sf.Type.Kind() == reflect.Struct && sf.Type != timeType {
+				continue
+			}
+			fields = append(fields, fileField{name: name, kind: kindOf(sf.Type), index: sf.Index})
+		}
+	case reflect.Slice:
+		for i, name := range columns {
+			kind := kindString
+			if i < rv.Len() && !rv.Index(i).IsNil() {
+				kind = kindOf(rv.Index(i).Elem().Type())
+			}
+			fields = append(fields, fileField{name: name, kind: kind, pos: i})
+		}
+	}
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("%w: file sink cannot derive columns from %T", ErrInvalidConfig, first)
+	}
+	return fields, nil
+}
+
+func kindOf(t reflect.Type) fileKind {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t == timeType {
+		return kindTimestamp
+	}
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return kindInt
+	case reflect.Float32, reflect.Float64:
+		return kindFloat
+	case reflect.Bool:
+		return kindBool
+	}
+	return kindString
+}
+
+// convert returns v as the Go type of the field kind: int64, float64, bool,
+// time.Time or string. Values of another kind in a slice row become strings.
+func (f fileField) convert(v reflect.Value) any {
+	for v.Kind() == reflect.Pointer || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return nil
+		}
+		v = v.Elem()
+	}
+	switch {
+	case f.kind == kindInt && v.CanInt():
+		return v.Int()
+	case f.kind == kindInt && v.CanUint():
+		return int64(v.Uint())
+	case f.kind == kindFloat && v.CanFloat():
+		return v.Float()
+	case f.kind == kindBool && v.Kind() == reflect.Bool:
+		return v.Bool()
+	case f.kind == kindString && v.Kind() == reflect.String:
+		return v.String()
+	}
+	if t, ok := v.Interface().(time.Time); ok {
+		if f.kind == kindTimestamp {
+			return t
+		}
+		return t.Format(time.RFC3339Nano)
+	}
+	return fmt.Sprint(v.Interface())
+}
+
+type rowWriter interface {
+	write(values []any) error
+	close() error
+}
+
+type ndjsonWriter struct {
+	gz     *gzip.Writer
+	buf    *bufio.Writer
+	fields []fileField
+	names  [][]byte
+}
+
+func newNDJSONWriter(w io.Writer, fields []fileField) *ndjsonWriter {
+	gz := gzip.NewWriter(w)
+	names := make([][]byte, len(fields))
+	for i, f := range fields {
+		names[i], _ = json.Marshal(f.name)
+	}
+	return &ndjsonWriter{gz: gz, buf: bufio.NewWriterSize(gz, 256*1024), fields: fields, names: names}
+}
+
+// write keeps the field order, which a map would not.
+func (w *ndjsonWriter) write(values []any) error {
+	w.buf.WriteByte('{')
+	for i, v := range values {
+		if i > 0 {
+			w.buf.WriteByte(',')
+		}
+		raw, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		w.buf.Write(w.names[i])
+		w.buf.WriteByte(':')
+		w.buf.Write(raw)
+	}
+	w.buf.WriteByte('}')
+	return w.buf.WriteByte('\n')
+}
+
+func (w *ndjsonWriter) close() error {
+	if err := w.buf.Flush(); err != nil {
+		return err
+	}
+	return w.gz.Close()
+}
+
+type csvWriter struct {
+	gz     *gzip.Writer
+	csv    *csv.Writer
+	record []string
+}
+
+func newCSVWriter(w io.Writer, fields []fileField, comma rune) (*csvWriter, error) {
+	gz := gzip.NewWriter(w)
+	out := &csvWriter{gz: gz, csv: csv.NewWriter(gz), record: make([]string, len(fields))}
+	out.csv.Comma = comma
+	for i, f := range fields {
+		out.record[i] = f.name
+	}
+	return out, out.csv.Write(out.record)
+}
+
+func (w *csvWriter) write(values []any) error {
+	for i, v := range values {
+		switch v := v.(type) {
+		case nil:
+			w.record[i] = ""
+		case string:
+			w.record[i] = v
+		case float64:
+			w.record[i] = strconv.FormatFloat(v, 'f', -1, 64)
+		case time.Time:
+			w.record[i] = v.Format(time.RFC3339Nano)
+		default:
+			w.record[i] = fmt.Sprint(v)
+		}
+	}
+	return w.csv.Write(w.record)
+}
+
+func (w *csvWriter) close() error {
+	w.csv.Flush()
+	if err := w.csv.Error(); err != nil {
+		return err
+	}
+	return w.gz.Close()
+}
+
+type parquetWriter struct {
+	pw *writer.CSVWriter
+}
+
+// parquetTypes are the Parquet column metadata of each field kind; every
+// column is optional.
+var parquetTypes = [...]string{
+	kindString: "type=BYTE_ARRAY, convertedtype=UTF8",
+	kindInt:    "type=INT64",
+	kindFloat:  "type=DOUBLE",
+	kindBool:   "type=BOOLEAN",
+	// Timestamps are written as microseconds since the epoch, in UTC.
+	kindTimestamp: "type=INT64, convertedtype=TIMESTAMP_MICROS",
+}
+
+func newParquetWriter(w io.Writer, fields []fileField) (*parquetWriter, error) {
+	md := make([]string, len(fields))
+	for i, f := range fields {
+		md[i] = fmt.Sprintf("name=%s, %s, repetitiontype=OPTIONAL", f.name, parquetTypes[f.kind])
+	}
+	pw, err := writer.NewCSVWriterFromWriter(md, w, 4)
+	if err != nil {
+		return nil, err
+	}
+	pw.CompressionType = parquet.CompressionCodec_SNAPPY
+	return &parquetWriter{pw: pw}, nil
+}
+
+func (w *parquetWriter) write(values []any) error {
+	for i, v := range values {
+		if t, ok := v.(time.Time); ok {
+			values[i] = t.UnixMicro()
+		}
+	}
+	return w.pw.Write(values)
+}
+
+func (w *parquetWriter) close() error {
+	return w.pw.WriteStop()
+}
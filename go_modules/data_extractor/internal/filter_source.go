@@ -0,0 +1,33 @@
+package internal
+
+import "context"
+
+// FilterSource drops items for which filter returns an error, passing the
+// error (usually a RejectError) up so the pipeline can count it.
+type FilterSource[T any] struct {
+	Source[T]
+	filter func(T) error
+}
+
+func NewFilterSource[T any](src Source[T], filter func(T) error) Source[T] {
+	return &FilterSource[T]{Source: src, filter: filter}
+}
+
+func (s *FilterSource[T]) Next(ctx context.Context) (T, error) {
+	v, err := s.Source.Next(ctx)
+	if err != nil {
+		return v, err
+	}
+	if err := s.filter(v); err != nil {
+		var zero T
+		return zero, err
+	}
+	return v, nil
+}
+
+func (s *FilterSource[T]) BytesRead() int64 {
+	if c, ok := s.Source.(ByteCounter); ok {
+		return c.BytesRead()
+	}
+	return 0
+}
@@ -0,0 +1,194 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// SyncMode is what a full sync does with the rows of its table whose keys the
+// source no longer has. Without one the sink only upserts.
+type SyncMode string
+
+const (
+	SyncModeDelete SyncMode = "delete"
+	// SyncModeTombstone marks the rows in SyncPolicy.TombstoneColumn and
+	// clears the mark of rows that come back.
+	SyncModeTombstone SyncMode = "tombstone"
+)
+
+func ParseSyncMode(s string) (SyncMode, error) {
+	switch mode := SyncMode(s); mode {
+	case "", SyncModeDelete, SyncModeTombstone:
+		return mode, nil
+	}
+	return "", fmt.Errorf("%w: invalid sync mode %q, expected delete or tombstone", ErrInvalidConfig, s)
+}
+
+type SyncPolicy struct {
+	Mode SyncMode
+	// TombstoneColumn is set to now() on vanished rows when it is a
+	// timestamp, or to false when it is a boolean flag like active. Default
+	// deleted_at.
+	TombstoneColumn string
+	// MaxRemovedPercent aborts the sync when a larger share of the live rows
+	// would be removed, which usually means a truncated source. Default 10.
+	MaxRemovedPercent float64
+}
+
+func (p SyncPolicy) Default() SyncPolicy {
+	if p.TombstoneColumn == "" {
+		p.TombstoneColumn = "deleted_at"
+	}
+	if p.MaxRemovedPercent <= 0 {
+		p.MaxRemovedPercent = 10
+	}
+	return p
+}
+
+// fullSync records the key of every row a sink writes in an unlogged table,
+// <table>_sync_keys, so the rows left out can be found once the run is over.
+// The table is recreated by the first batch of each run.
+type fullSync struct {
+	pool      *pgxpool.Pool
+	spec      TableSpec
+	policy    SyncPolicy
+	keysTable string
+
+	mu       sync.Mutex
+	prepared bool
+}
+
+func newFullSync(pool *pgxpool.Pool, spec TableSpec) *fullSync {
+	return &fullSync{pool: pool, spec: spec, policy: spec.Sync.Default(), keysTable: spec.Name + "_sync_keys"}
+}
+
+func (s *fullSync) prepare(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.prepared {
+		return nil
+	}
+	if len(s.spec.keyColumns()) != len(s.spec.conflictKey()) {
+		return fmt.Errorf("%w: table %s: full sync needs a conflict key of plain columns", ErrInvalidConfig, s.spec.Name)
+	}
+	sql := fmt.Sprintf("DROP TABLE IF EXISTS %[1]s; CREATE UNLOGGED TABLE %[1]s AS SELECT %[2]s FROM %[3]s WITH NO DATA",
+		s.keysTable, strings.Join(s.spec.keyColumns(), ", "), s.spec.Name)
+	if _, err := s.pool.Exec(ctx, sql); err != nil {
+		return fmt.Errorf("%w: error creating %s: %w", classifyPGError(err), s.keysTable, err)
+	}
+	s.prepared = true
+	return nil
+}
+
+// track records the keys of rows, encoded for spec.
+func (s *fullSync) track(ctx context.Context, rows [][]any) error {
+	if err := s.prepare(ctx); err != nil {
+		return err
+	}
+	indexes := s.spec.keyIndexes()
+	keys := make([][]any, len(rows))
+	for i, row := range rows {
+		key := make([]any, len(indexes))
+		for j, k := range indexes {
+			key[j] = row[k]
+		}
+		keys[i] = key
+	}
+	_, err := s.pool.CopyFrom(ctx, pgx.Identifier(strings.Split(s.keysTable, ".")), s.spec.keyColumns(), pgx.CopyFromRows(keys))
+	if err != nil {
+		return fmt.Errorf("%w: error recording synced keys: %w", classifyPGError(err), err)
+	}
+	return nil
+}
+
+// finish removes the live rows whose keys were not tracked, in one
+// transaction, and returns how many there were.
+func (s *fullSync) finish(ctx context.Context) (int64, error) {
+	if err := s.prepare(ctx); err != nil {
+		return 0, err
+	}
+
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("%w: error starting transaction: %w", classifyPGError(err), err)
+	}
+	defer tx.Rollback(ctx)
+
+	live, remove, revive := "true", "", ""
+	if s.policy.Mode == SyncModeTombstone {
+		if live, remove, revive, err = s.tombstone(ctx, tx); err != nil {
+			return 0, err
+		}
+	}
+
+	match := make([]string, 0, len(s.spec.keyColumns()))
+	for _, col := range s.spec.keyColumns() {
+		match = append(match, fmt.Sprintf("k.%[1]s = t.%[1]s", col))
+	}
+	seen := fmt.Sprintf("EXISTS (SELECT 1 FROM %s k WHERE %s)", s.keysTable, strings.Join(match, " AND "))
+
+	// The keys table has no statistics yet, without them the anti-join plan
+	// can be a nested loop.
+	if _, err := tx.Exec(ctx, "ANALYZE "+s.keysTable); err != nil {
+		return 0, fmt.Errorf("%w: %w", classifyPGError(err), err)
+	}
+	var total, vanished int64
+	err = tx.QueryRow(ctx, fmt.Sprintf("SELECT count(*), count(*) FILTER (WHERE NOT %s) FROM %s t WHERE %s", seen, s.spec.Name, live)).Scan(&total, &vanished)
+	if err != nil {
+		return 0, fmt.Errorf("%w: error counting vanished rows: %w", classifyPGError(err), err)
+	}
+	if percent := float64(vanished) * 100 / float64(max(total, 1)); percent > s.policy.MaxRemovedPercent {
+		return 0, fmt.Errorf("%w: full sync of %s would remove %d of %d rows (%.1f%%), over the %.1f%% limit",
+			ErrInvalidData, s.spec.Name, vanished, total, percent, s.policy.MaxRemovedPercent)
+	}
+
+	statements := []string{fmt.Sprintf("DELETE FROM %s t WHERE NOT %s", s.spec.Name, seen)}
+	if s.policy.Mode == SyncModeTombstone {
+		statements = []string{
+			fmt.Sprintf("UPDATE %s t SET %s WHERE %s AND NOT %s", s.spec.Name, remove, live, seen),
+			fmt.Sprintf("UPDATE %s t SET %s WHERE NOT (%s) AND %s", s.spec.Name, revive, live, seen),
+		}
+	}
+	statements = append(statements, "DROP TABLE "+s.keysTable)
+	for _, sql := range statements {
+		if _, err := tx.Exec(ctx, sql); err != nil {
+			return 0, fmt.Errorf("%w: error removing vanished rows: %w", classifyPGError(err), err)
+		}
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return 0, fmt.Errorf("%w: error committing full sync: %w", classifyPGError(err), err)
+	}
+
+	s.mu.Lock()
+	s.prepared = false
+	s.mu.Unlock()
+	slog.InfoContext(ctx, "Full sync finished", "table", s.spec.Name, "mode", string(s.policy.Mode), "removed", vanished, "rows", total)
+	return vanished, nil
+}
+
+// tombstone returns the predicate of live rows and the assignments that
+// remove and revive a row, depending on the type of the tombstone column.
+func (s *fullSync) tombstone(ctx context.Context, tx pgx.Tx) (string, string, string, error) {
+	col := s.policy.TombstoneColumn
+	var typ string
+	err := tx.QueryRow(ctx, `
+		SELECT format_type(atttypid, atttypmod) FROM pg_attribute
+		WHERE attrelid = $1::regclass AND attname = $2 AND NOT attisdropped`, s.spec.Name, col).Scan(&typ)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", "", "", fmt.Errorf("%w: table %s has no tombstone column %s", ErrSchemaMismatch, s.spec.Name, col)
+	}
+	if err != nil {
+		return "", "", "", fmt.Errorf("%w: %w", classifyPGError(err), err)
+	}
+	if typ == "boolean" {
+		return col + " IS NOT FALSE", col + " = false", col + " = true", nil
+	}
+	return col + " IS NULL", col + " = now()", col + " = NULL", nil
+}
@@ -0,0 +1,92 @@
+package internal
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// HistorySpec is where a table keeps the versions of its rows replaced by an
+// update, as a type 2 slowly changing dimension. A history row holds the key
+// and tracked columns of the old version, valid_from (the valid_to of the
+// version before it, NULL for the first one), valid_to (when it was replaced)
+// and source_release, the YYYY-MM release whose load replaced it. It is only
+// written when PGOptions.History is set and needs an update conflict mode on a
+// key of plain columns.
+type HistorySpec struct {
+	Table string
+	// Columns are the tracked columns: a row is versioned when one of them
+	// changes.
+	Columns []string
+}
+
+// HistoryTable is the TableSpec of the history table of s, used to provision
+// and check it; false when s keeps no history.
+func (s TableSpec) HistoryTable() (TableSpec, bool) {
+	if s.History.Table == "" {
+		return TableSpec{}, false
+	}
+	key := s.keyColumns()
+	history := TableSpec{
+		Name:    s.History.Table,
+		Columns: append(slices.Concat(key, s.History.Columns), "valid_from", "valid_to", "source_release"),
+		NotNull: append(slices.Clone(key), "valid_to"),
+	}
+	for _, col := range slices.Concat(key, s.History.Columns) {
+		typ := ""
+		if i := slices.Index(s.Columns, col); i >= 0 && i < len(s.Types) {
+			typ = s.Types[i]
+		}
+		history.Types = append(history.Types, typ)
+	}
+	history.Types = append(history.Types, "timestamptz", "timestamptz", "varchar(7)")
+	return history, true
+}
+
+// withHistory wraps the upsert statement insert, which has no RETURNING
+// clause yet, so that it also copies the versions it replaces into the
+// history table. Every part of a statement sees the table as it was before
+// the statement, which is how the join on o reads the old version.
+func (s TableSpec) withHistory(insert, release string) string {
+	key := s.keyColumns()
+	tracked := s.History.Columns
+	join := make([]string, len(key))
+	previous := make([]string, len(key))
+	for i, col := range key {
+		join[i] = fmt.Sprintf("o.%[1]s = u.%[1]s", col)
+		previous[i] = fmt.Sprintf("h.%[1]s = o.%[1]s", col)
+	}
+	old := slices.Concat(key, tracked)
+	for i, col := range old {
+		old[i] = "o." + col
+	}
+	incoming := make([]string, len(tracked))
+	current := make([]string, len(tracked))
+	for i, col := range tracked {
+		incoming[i] = "u." + col
+		current[i] = "o." + col
+	}
+
+	return fmt.Sprintf(`WITH upserted AS (
+	%s RETURNING (xmax = 0) AS inserted, %s
+), history AS (
+	INSERT INTO %s (%s, valid_from, valid_to, source_release)
+	SELECT %s, (SELECT max(h.valid_to) FROM %s h WHERE %s), now(), %s
+	FROM upserted u JOIN %s o ON %s
+	WHERE NOT u.inserted AND ROW(%s) IS DISTINCT FROM ROW(%s)
+)
+SELECT inserted FROM upserted`,
+		insert, strings.Join(slices.Concat(key, tracked), ", "),
+		s.History.Table, strings.Join(slices.Concat(key, tracked), ", "),
+		strings.Join(old, ", "), s.History.Table, strings.Join(previous, " AND "), quoteLiteral(release),
+		s.Name, strings.Join(join, " AND "),
+		strings.Join(current, ", "), strings.Join(incoming, ", "))
+}
+
+// quoteLiteral quotes s as an SQL string literal, NULL when empty.
+func quoteLiteral(s string) string {
+	if s == "" {
+		return "NULL"
+	}
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
@@ -5,20 +5,24 @@ import (
 	"context"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"os"
 	"path/filepath"
 	"time"
 
+	"github.com/arko_tech_challenge/go_modules/data_extractor/internal/metrics"
 	"github.com/cavaliergopher/grab/v3"
 )
 
 type Downloader interface {
 	Download(ctx context.Context, url string, storagePath string) error
 	Extract(ctx context.Context, storagePath string, extractPath string) error
+	BytesDownloaded() int64
 }
 
-type HTTPDownloader struct{}
+type HTTPDownloader struct {
+	bytesDownloaded int64
+}
 
 func NewHTTPDownloader() Downloader {
 	return &HTTPDownloader{}
@@ -31,36 +35,38 @@ func (d *HTTPDownloader) fileExists(path string) bool {
 
 func (d *HTTPDownloader) Download(ctx context.Context, url string, storagePath string) error {
 	if d.fileExists(storagePath) {
-		log.Printf("File already exists: %s", storagePath)
+		slog.InfoContext(ctx, "File already downloaded", "path", storagePath)
 		return nil
-	} else {
-		if err := os.MkdirAll(storagePath, 0755); err != nil {
-			log.Fatalf("Failed to create storage directory: %v", err)
-		}
+	}
+	if err := os.MkdirAll(filepath.Dir(storagePath), 0755); err != nil {
+		return fmt.Errorf("%w: error creating storage directory: %w", ErrDownloadFailed, err)
 	}
 
 	client := grab.NewClient()
 
 	req, err := grab.NewRequest(storagePath, url)
 	if err != nil {
-		return fmt.Errorf("error creating request: %w", err)
+		return fmt.Errorf("%w: error creating request: %w", ErrDownloadFailed, err)
 	}
+	req = req.WithContext(ctx)
 
-	fmt.Printf("Downloading %v...\n", req.URL())
+	slog.InfoContext(ctx, "Downloading", "url", req.URL().String())
 	resp := client.Do(req)
-	fmt.Printf("  %v\n", resp.HTTPResponse.Status)
+	if resp.HTTPResponse != nil {
+		slog.DebugContext(ctx, "Download response", "status", resp.HTTPResponse.Status)
+	}
 
-	t := time.NewTicker(2 * time.Second)
+	t := time.NewTicker(10 * time.Second)
 	defer t.Stop()
 
 Loop:
 	for {
 		select {
 		case <-t.C:
-			log.Printf("  transferred %v / %v bytes (%.2f%%)\n",
-				resp.BytesComplete(),
-				resp.Size(),
-				100*resp.Progress())
+			slog.InfoContext(ctx, "Download progress",
+				"bytes", resp.BytesComplete(),
+				"size", resp.Size(),
+				"percent", fmt.Sprintf("%.2f", 100*resp.Progress()))
 
 		case <-resp.Done:
 			break Loop
@@ -68,39 +74,46 @@ Loop:
 	}
 
 	if err := resp.Err(); err != nil {
-		fmt.Fprintf(os.Stderr, "Download failed: %v\n", err)
-		os.Exit(1)
+		if resp.Filename != "" {
+			_ = os.Remove(resp.Filename)
+		}
+		return fmt.Errorf("%w: %s: %w", ErrDownloadFailed, url, err)
 	}
 
-	fmt.Printf("Download saved to ./%v \n", resp.Filename)
+	d.bytesDownloaded += resp.BytesComplete()
+	metrics.BytesDownloaded.Add(float64(resp.BytesComplete()))
+	slog.InfoContext(ctx, "Download saved", "path", resp.Filename, "bytes", resp.BytesComplete())
 
 	return nil
 }
 
+func (d *HTTPDownloader) BytesDownloaded() int64 {
+	return d.bytesDownloaded
+}
+
 func (e *HTTPDownloader) Extract(ctx context.Context, source string, destDir string) error {
 	if e.alreadyExtracted(destDir) {
-		log.Printf("Files already extracted: %s", destDir)
+		slog.InfoContext(ctx, "Files already extracted", "path", destDir)
 		return nil
-	} else {
-		if err := os.MkdirAll(destDir, 0755); err != nil {
-			log.Fatalf("Failed to create extract directory: %v", err)
-		}
+	}
+	if err := os.MkdirAll(destDir, 0755); err != nil {
+		return fmt.Errorf("%w: error creating extract directory: %w", ErrDownloadFailed, err)
 	}
 
 	reader, err := zip.OpenReader(source)
 	if err != nil {
-		return fmt.Errorf("error opening zip file: %w", err)
+		return fmt.Errorf("%w: error opening zip file: %w", ErrDownloadFailed, err)
 	}
 	defer reader.Close()
 
 	for _, file := range reader.File {
 		err := e.extractFile(ctx, file, destDir)
 		if err != nil {
-			return fmt.Errorf("error extracting file %s: %w", file.Name, err)
+			return fmt.Errorf("%w: error extracting file %s: %w", ErrDownloadFailed, file.Name, err)
 		}
 	}
 
-	log.Printf("Extracted files to %s", destDir)
+	slog.InfoContext(ctx, "Extracted files", "path", destDir, "files", len(reader.File))
 
 	return nil
 }
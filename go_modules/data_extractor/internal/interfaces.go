@@ -1,12 +1,39 @@
 package internal
 
-import "context"
+import (
+	"context"
+	"errors"
+)
+
+// ErrSkipRecord can be returned by a source mapper to drop a record without
+// failing the pipeline.
+var ErrSkipRecord = errors.New("skip record")
+
+// RejectError is an ErrSkipRecord that carries the reason the record was
+// dropped, so run reports can group rejections.
+type RejectError struct {
+	Reason string
+}
+
+func Reject(reason string) error {
+	return &RejectError{Reason: reason}
+}
+
+func (e *RejectError) Error() string {
+	return "record rejected: " + e.Reason
+}
+
+func (e *RejectError) Is(target error) bool {
+	return target == ErrSkipRecord
+}
 
 type Batcher[T any] interface {
 	Push(ctx context.Context, item T) (read bool, batch []T, err error)
 	Flush(ctx context.Context) (batch []T, err error)
 	Progress() string
 	AddUpsertedBatch()
+	Upserted() int
+	Total() int
 }
 
 type DBEncoder[T any] interface {
@@ -24,6 +51,59 @@ type Identifiable interface {
 	ID() string
 }
 
+// SourcePosition is where a record was read from.
+type SourcePosition struct {
+	File string
+	Line int
+}
+
+// Positioned is implemented by rows that remember their SourcePosition, so
+// a row the sink rejects can be traced back to the input.
+type Positioned interface {
+	SourcePosition() SourcePosition
+}
+
 type Sink[T any] interface {
 	WriteBatch(ctx context.Context, batch []T) error
 }
+
+type WriteCounts struct {
+	Inserted     int64
+	Updated      int64
+	DeadLettered int64
+	// Removed are the rows deleted or tombstoned by a full sync.
+	Removed int64
+	// Duplicates are the rows dropped for repeating a conflict key within
+	// their batch.
+	Duplicates int64
+}
+
+// WriteCounter is implemented by sinks that can tell inserted rows from
+// updated ones.
+type WriteCounter interface {
+	WriteCounts() WriteCounts
+}
+
+// Finisher is implemented by sinks with work to do once every batch of a run
+// was written successfully.
+type Finisher interface {
+	Finish(ctx context.Context) error
+}
+
+// Beginner is implemented by sinks with work to do before the first batch of
+// a run.
+type Beginner interface {
+	Begin(ctx context.Context) error
+}
+
+// Ender is implemented by sinks with work to do once a run is over, whether
+// it succeeded or not; err is how it ended.
+type Ender interface {
+	End(ctx context.Context, err error) error
+}
+
+// ByteCounter is implemented by sources that know how many bytes they have
+// read from the network or disk.
+type ByteCounter interface {
+	BytesRead() int64
+}
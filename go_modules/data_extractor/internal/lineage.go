@@ -0,0 +1,80 @@
+package internal
+
+import (
+	"context"
+	"slices"
+	"time"
+)
+
+// lineageColumns are appended to the columns of a table loaded with
+// PGOptions.Lineage, with lineageTypes as their types when provisioned.
+var (
+	lineageColumns = []string{"load_run_id", "source_uri", "source_line", "loaded_at"}
+	lineageTypes   = []string{"text", "text", "integer", "timestamptz"}
+)
+
+type lineageKey struct{}
+
+// Lineage is the run context the Postgres sink stamps on every row it writes
+// when PGOptions.Lineage is set.
+type Lineage struct {
+	RunID string
+	// SourceUri is the endpoint or file the rows come from, like a Receita
+	// zip URL followed by !/ and the member file. Rows that implement
+	// Positioned with a file override it and supply source_line.
+	SourceUri string
+}
+
+// WithLineage returns a context carrying l, on top of the non-empty fields
+// of the Lineage already in ctx.
+func WithLineage(ctx context.Context, l Lineage) context.Context {
+	current := LineageFrom(ctx)
+	if l.RunID == "" {
+		l.RunID = current.RunID
+	}
+	if l.SourceUri == "" {
+		l.SourceUri = current.SourceUri
+	}
+	return context.WithValue(ctx, lineageKey{}, l)
+}
+
+func LineageFrom(ctx context.Context) Lineage {
+	l, _ := ctx.Value(lineageKey{}).(Lineage)
+	return l
+}
+
+// WithLineageColumns is s with the lineage columns appended. They are
+// updated on conflict along with the other update columns, but a change in
+// them alone does not make a row changed for ConflictModeUpdateChanged.
+func (s TableSpec) WithLineageColumns() TableSpec {
+	s.Columns = slices.Concat(s.Columns, lineageColumns)
+	if len(s.Types) > 0 {
+		s.Types = slices.Concat(s.Types, lineageTypes)
+	}
+	if len(s.UpdateColumns) > 0 {
+		s.UpdateColumns = slices.Concat(s.UpdateColumns, lineageColumns)
+	}
+	return s
+}
+
+func isLineageColumn(col string) bool {
+	return slices.Contains(lineageColumns, col)
+}
+
+// lineageValues are the values of the lineage columns of item.
+func lineageValues(l Lineage, item any, loadedAt time.Time) []any {
+	uri, line := l.SourceUri, any(nil)
+	if p, ok := item.(Positioned); ok {
+		if pos := p.SourcePosition(); pos.File != "" {
+			uri, line = pos.File, pos.Line
+		}
+	}
+	return []any{nullIfEmpty(l.RunID), nullIfEmpty(uri), line, loadedAt}
+}
+
+func nullIfEmpty(s string) any {
+	if s == "" {
+		return nil
+	}
+	return s
+}
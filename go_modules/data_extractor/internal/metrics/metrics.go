@@ -0,0 +1,157 @@
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Registry holds every extractor metric. It is served on /metrics by serve
+// and written as a node_exporter textfile by run -metrics-file.
+var Registry = prometheus.NewRegistry()
+
+var (
+	RowsRead = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "extractor_rows_read_total",
+		Help: "Records read from the pipeline source.",
+	}, []string{"pipeline"})
+
+	RowsRejected = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "extractor_rows_rejected_total",
+		Help: "Records dropped while mapping or filtering, by reason.",
+	}, []string{"pipeline", "reason"})
+
+	BatchesWritten = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "extractor_batches_written_total",
+		Help: "Batches successfully written to the sink.",
+	}, []string{"pipeline"})
+
+	RowsWritten = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "extractor_rows_written_total",
+		Help: "Rows in batches successfully written to the sink.",
+	}, []string{"pipeline"})
+
+	WriteErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "extractor_write_errors_total",
+		Help: "WriteBatch calls that failed.",
+	}, []string{"pipeline"})
+
+	TargetErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "extractor_target_errors_total",
+		Help: "Failed writes to one target of a fan-out sink, best-effort ones included.",
+	}, []string{"pipeline", "target"})
+
+	// WriteRetries is incremented by the retry loop of the Postgres sink, see
+	// internal.RetryPolicy; it stays at zero for sinks without one.
+	WriteRetries = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "extractor_write_retries_total",
+		Help: "Postgres batch writes retried after a transient error, per internal.RetryPolicy.",
+	}, []string{"pipeline"})
+
+	WriteDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "extractor_write_batch_duration_seconds",
+		Help:    "Latency of Sink.WriteBatch.",
+		Buckets: prometheus.ExponentialBuckets(0.005, 2, 14),
+	}, []string{"pipeline"})
+
+	QueueDepth = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "extractor_batch_queue_depth",
+		Help: "Batches waiting in RunPipeline for a sink worker.",
+	}, []string{"pipeline"})
+
+	BytesDownloaded = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "extractor_download_bytes_total",
+		Help: "Bytes downloaded by HTTPDownloader.",
+	})
+
+	PipelineRuns = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "extractor_pipeline_runs_total",
+		Help: "Finished pipeline runs, by status.",
+	}, []string{"pipeline", "status"})
+
+	PipelineDuration = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "extractor_pipeline_last_duration_seconds",
+		Help: "Duration of the last run of each pipeline.",
+	}, []string{"pipeline"})
+
+	PipelineLastSuccess = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "extractor_pipeline_last_success_timestamp_seconds",
+		Help: "Unix time of the last successful run of each pipeline.",
+	}, []string{"pipeline"})
+)
+
+func init() {
+	Registry.MustRegister(
+		RowsRead,
+		RowsRejected,
+		BatchesWritten,
+		RowsWritten,
+		WriteErrors,
+		TargetErrors,
+		WriteRetries,
+		WriteDuration,
+		QueueDepth,
+		BytesDownloaded,
+		PipelineRuns,
+		PipelineDuration,
+		PipelineLastSuccess,
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+}
+
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{})
+}
+
+// WriteTextfile writes the current values for the node_exporter textfile
+// collector, atomically replacing path.
+func WriteTextfile(path string) error {
+	return prometheus.WriteToTextfile(path, Registry)
+}
+
+// RegisterPool exports the pgxpool statistics of pool.
+func RegisterPool(pool *pgxpool.Pool) error {
+	return Registry.Register(&poolCollector{pool: pool})
+}
+
+var (
+	poolAcquiredConns = prometheus.NewDesc("extractor_pgxpool_acquired_conns", "Connections currently checked out of the pool.", nil, nil)
+	poolIdleConns     = prometheus.NewDesc("extractor_pgxpool_idle_conns", "Idle connections in the pool.", nil, nil)
+	poolTotalConns    = prometheus.NewDesc("extractor_pgxpool_total_conns", "Open connections in the pool.", nil, nil)
+	poolMaxConns      = prometheus.NewDesc("extractor_pgxpool_max_conns", "Maximum size of the pool.", nil, nil)
+	poolAcquires      = prometheus.NewDesc("extractor_pgxpool_acquires_total", "Successful connection acquires.", nil, nil)
+	poolEmptyAcquires = prometheus.NewDesc("extractor_pgxpool_empty_acquires_total", "Acquires that had to wait for a connection.", nil, nil)
+	poolAcquireWait   = prometheus.NewDesc("extractor_pgxpool_acquire_wait_seconds_total", "Time spent acquiring connections.", nil, nil)
+	poolCanceled      = prometheus.NewDesc("extractor_pgxpool_canceled_acquires_total", "Acquires canceled by their context.", nil, nil)
+)
+
+type poolCollector struct {
+	pool *pgxpool.Pool
+}
+
+func (c *poolCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- poolAcquiredConns
+	ch <- poolIdleConns
+	ch <- poolTotalConns
+	ch <- poolMaxConns
+	ch <- poolAcquires
+	ch <- poolEmptyAcquires
+	ch <- poolAcquireWait
+	ch <- poolCanceled
+}
+
+func (c *poolCollector) Collect(ch chan<- prometheus.Metric) {
+	s := c.pool.Stat()
+	ch <- prometheus.MustNewConstMetric(poolAcquiredConns, prometheus.GaugeValue, float64(s.AcquiredConns()))
+	ch <- prometheus.MustNewConstMetric(poolIdleConns, prometheus.GaugeValue, float64(s.IdleConns()))
+	ch <- prometheus.MustNewConstMetric(poolTotalConns, prometheus.GaugeValue, float64(s.TotalConns()))
+	ch <- prometheus.MustNewConstMetric(poolMaxConns, prometheus.GaugeValue, float64(s.MaxConns()))
+	ch <- prometheus.MustNewConstMetric(poolAcquires, prometheus.CounterValue, float64(s.AcquireCount()))
+	ch <- prometheus.MustNewConstMetric(poolEmptyAcquires, prometheus.CounterValue, float64(s.EmptyAcquireCount()))
+	ch <- prometheus.MustNewConstMetric(poolAcquireWait, prometheus.CounterValue, s.AcquireDuration().Seconds())
+	ch <- prometheus.MustNewConstMetric(poolCanceled, prometheus.CounterValue, float64(s.CanceledAcquireCount()))
+}
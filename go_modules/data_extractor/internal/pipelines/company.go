@@ -6,7 +6,7 @@ import (
 	"encoding/csv"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"os"
 	"path/filepath"
 	"strconv"
@@ -18,6 +18,19 @@ import (
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
+var companiesTable = internal.TableSpec{
+	Name:            "company",
+	Columns:         []string{"cnpj", "social_name", "juridical_nature", "responsible_qualification", "social_capital", "company_size", "federative_entity"},
+	Types:           []string{"varchar(8)", "varchar(255)", "varchar(4)", "varchar(2)", "numeric(15,2)", "varchar(2)", "varchar(100)"},
+	ConflictMode:    internal.ConflictModeUpdateChanged,
+	ConflictColumns: []string{"cnpj"},
+	UpdateColumns:   []string{"social_name", "juridical_nature", "responsible_qualification", "social_capital", "company_size", "federative_entity"},
+	History: internal.HistorySpec{
+		Table:   "company_history",
+		Columns: []string{"social_name", "social_capital", "company_size"},
+	},
+}
+
 type Company struct {
 	CNPJ                     string  `csv:"cnpj"`
 	SocialName               string  `csv:"social_name"`
@@ -26,8 +39,21 @@ type Company struct {
 	SocialCapital            float64 `csv:"social_capital"`
 	CompanySize              string  `csv:"company_size"`
 	FederativeEntity         string  `csv:"federative_entity"`
+
+	Position internal.SourcePosition `csv:"-"`
 }
 
+func (c Company) SourcePosition() internal.SourcePosition {
+	return c.Position
+}
+
+// ID is the conflict key of a company, its CNPJ base.
+func (c Company) ID() string {
+	return c.CNPJ
+}
+
+var _ internal.Identifiable = Company{}
+
 var _ internal.DBEncoder[Company] = &CompanyEncoder{}
 
 type CompanyEncoder struct {
@@ -50,42 +76,53 @@ func (e *CompanyEncoder) Encode(ctx context.Context, v Company) ([]any, error) {
 	}, nil
 }
 
-func RunCompaniesPipeline(ctx context.Context, pool *pgxpool.Pool, downloadUrl, downloadPath, extractPath string, batchSize int) error {
+func RunCompaniesPipeline(ctx context.Context, pool *pgxpool.Pool, downloadUrl, downloadPath, extractPath string, settings Settings) (Stats, error) {
+	var stats Stats
 	downloader := internal.NewHTTPDownloader()
 
-	log.Println("Downloading file...")
+	stageStart := time.Now()
 	if err := downloader.Download(ctx, downloadUrl, downloadPath); err != nil {
-		log.Fatalf("Failed to download file: %v", err)
+		return stats, err
 	}
+	stats.AddStage("download", time.Since(stageStart))
+	stats.BytesDownloaded = downloader.BytesDownloaded()
 
-	log.Println("Extracting file...")
+	stageStart = time.Now()
 	if err := downloader.Extract(ctx, downloadPath, extractPath); err != nil {
-		log.Fatalf("Failed to extract file: %v", err)
+		return stats, err
 	}
+	stats.AddStage("extract", time.Since(stageStart))
 
 	files, err := os.ReadDir(extractPath)
 	if err != nil {
-		log.Fatalf("Failed to read extract directory: %v", err)
+		return stats, fmt.Errorf("%w: failed to read extract directory: %w", internal.ErrDownloadFailed, err)
+	}
+	if len(files) == 0 {
+		return stats, fmt.Errorf("%w: %s is empty after extraction", internal.ErrDownloadFailed, extractPath)
 	}
 
 	extractedFilePath := filepath.Join(extractPath, files[0].Name())
 	csvPath := filepath.Join(extractPath, "companies.csv")
 	companyColumns := []string{"cnpj", "social_name", "juridical_nature", "responsible_qualification", "social_capital", "company_size", "federative_entity"}
 
-	itemCount, err := convertToCSV(extractedFilePath, csvPath, companyColumns, 10000000000)
+	stageStart = time.Now()
+	itemCount, err := convertToCSV(ctx, extractedFilePath, csvPath, companyColumns, 10000000000)
 	if err != nil {
-		log.Fatalf("Failed to convert CSV: %v", err)
+		return stats, fmt.Errorf("failed to convert CSV: %w", err)
 	}
-	log.Printf("Converted %d records to CSV", itemCount)
+	stats.AddStage("convert", time.Since(stageStart))
+	slog.InfoContext(ctx, "Converted records to CSV", "records", itemCount, "path", csvPath)
 
 	file, err := os.Open(csvPath)
 	if err != nil {
-		log.Fatalf("Failed to open CSV file: %v", err)
+		return stats, fmt.Errorf("failed to open CSV file: %w", err)
 	}
-	log.Printf("CSV file opened: %v", csvPath)
 	defer file.Close()
 
-	src := internal.NewCSVSource(file, ';', func(cols []string) (Company, error) {
+	// Positions point at the member of the zip rather than the converted
+	// file, whose header shifts every line by one.
+	sourceUri := downloadUrl + "!/" + files[0].Name()
+	src := internal.NewPositionedCSVSource(file, csvPath, ';', true, func(cols []string, pos internal.SourcePosition) (Company, error) {
 		rawCapital := strings.ReplaceAll(cols[4], ".", "")
 		rawCapital = strings.ReplaceAll(rawCapital, ",", ".")
 		capital, err := strconv.ParseFloat(rawCapital, 64)
@@ -100,26 +137,24 @@ func RunCompaniesPipeline(ctx context.Context, pool *pgxpool.Pool, downloadUrl,
 			SocialCapital:            capital,
 			CompanySize:              cols[5],
 			FederativeEntity:         cols[6],
+			Position:                 internal.SourcePosition{File: sourceUri, Line: pos.Line - 1},
 		}, nil
 	}, itemCount)
 	defer src.Close()
 
-	batcher := internal.NewFixedSizeBatcher[Company](batchSize, src.ItemCount())
+	batcher := internal.NewFixedSizeBatcher[Company](settings.BatchSize, src.ItemCount())
 	encoder := NewCompanyEncoder(pool)
-	tableSpec := internal.TableSpec{
-		Name:           "company",
-		Columns:        []string{"cnpj", "social_name", "juridical_nature", "responsible_qualification", "social_capital", "company_size", "federative_entity"},
-		ConflictMode:   internal.ConflictModeUpdate,
-		ConflictColumn: "cnpj",
-		UpdateColumns:  []string{"social_name", "juridical_nature", "responsible_qualification", "social_capital", "company_size", "federative_entity"},
-	}
-	db := internal.NewPostgresRepository(pool, tableSpec, encoder, internal.PGOptions{
+	db := newSink(pool, companiesTable, encoder, settings, internal.PGOptions{
 		TxTimeout: 10 * time.Second,
+		Release:   internal.ReleaseOf(downloadUrl),
 	})
-	return RunPipeline(ctx, src, batcher, db)
+	ctx = internal.WithLineage(ctx, internal.Lineage{SourceUri: sourceUri})
+	runStats, err := RunPipeline(ctx, src, batcher, db, settings.runOptions())
+	stats.Merge(runStats)
+	return stats, err
 }
 
-func convertToCSV(inputPath, outputPath string, headers []string, limit int) (int, error) {
+func convertToCSV(ctx context.Context, inputPath, outputPath string, headers []string, limit int) (int, error) {
 	in, err := os.Open(inputPath)
 	if err != nil {
 		return 0, fmt.Errorf("error opening input file: %w", err)
@@ -161,7 +196,7 @@ func convertToCSV(inputPath, outputPath string, headers []string, limit int) (in
 			break
 		}
 		if err != nil {
-			return 0, fmt.Errorf("error reading CSV: %w", err)
+			return 0, fmt.Errorf("%w: error reading CSV: %w", internal.ErrInvalidData, err)
 		}
 		record := make([]string, len(headers))
 		for i, val := range rec {
@@ -184,6 +219,9 @@ func convertToCSV(inputPath, outputPath string, headers []string, limit int) (in
 		if limit > 0 && recordCount >= limit {
 			break
 		}
+		if recordCount%100000 == 0 && ctx.Err() != nil {
+			return 0, ctx.Err()
+		}
 	}
 	w.Flush()
 
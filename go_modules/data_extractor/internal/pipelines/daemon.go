@@ -0,0 +1,244 @@
+package pipelines
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/arko_tech_challenge/go_modules/data_extractor/internal"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// DaemonState is persisted between serve restarts so a release is loaded
+// only once.
+type DaemonState struct {
+	Releases map[string]string    `json:"releases"`
+	LastRuns map[string]time.Time `json:"last_runs"`
+}
+
+type scheduledPipeline struct {
+	def      Definition
+	schedule internal.CronSchedule
+	next     time.Time
+}
+
+type Daemon struct {
+	pipelines []*scheduledPipeline
+	settings  Settings
+	statePath string
+	state     DaemonState
+	runs      *Runs
+}
+
+// NewDaemon keeps the definitions that have a schedule; the others are left
+// out of serve mode. Scheduled runs are recorded in runs, which may be shared
+// with the admin API.
+func NewDaemon(defs []Definition, settings Settings, statePath string, runs *Runs) (*Daemon, error) {
+	d := &Daemon{settings: settings, statePath: statePath, runs: runs}
+	for _, def := range defs {
+		if def.Schedule == "" {
+			slog.Info("Pipeline has no schedule, it will not run in serve mode", "pipeline", def.Name)
+			continue
+		}
+		schedule, err := internal.ParseCron(def.Schedule)
+		if err != nil {
+			return nil, fmt.Errorf("pipeline %s: %w", def.Name, err)
+		}
+		d.pipelines = append(d.pipelines, &scheduledPipeline{def: def, schedule: schedule})
+	}
+	if len(d.pipelines) == 0 {
+		return nil, fmt.Errorf("%w: none of the selected pipelines has a schedule", internal.ErrInvalidConfig)
+	}
+
+	if err := d.loadState(); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+func (d *Daemon) Plan() string {
+	plan := ""
+	for _, p := range d.pipelines {
+		line := fmt.Sprintf("  %-10s %s", p.def.Name, p.schedule)
+		if p.def.ReleaseUrl != nil {
+			line += ", on new release"
+			if release := d.state.Releases[p.def.Name]; release != "" {
+				line += " (loaded " + release + ")"
+			}
+		}
+		plan += line + "\n"
+	}
+	return plan
+}
+
+// Run fires the pipelines on their schedules until ctx is cancelled.
+// Pipelines due at the same minute run together through a Scheduler, so
+// dependencies between them are respected. Runs never overlap: pipelines due
+// while a run of them is in progress, such as one started through the admin
+// API, stay due and fire once the runs in progress end.
+func (d *Daemon) Run(ctx context.Context, pool *pgxpool.Pool) error {
+	now := time.Now()
+	for _, p := range d.pipelines {
+		p.next = p.schedule.Next(now)
+		slog.Info("Next scheduled run", "pipeline", p.def.Name, "at", p.next)
+	}
+
+	for {
+		next := d.nextRun()
+		if next.IsZero() {
+			return fmt.Errorf("%w: no schedule will ever fire again", internal.ErrInvalidConfig)
+		}
+
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil
+		case <-timer.C:
+		}
+
+		now := time.Now()
+		due := make([]*scheduledPipeline, 0, len(d.pipelines))
+		for _, p := range d.pipelines {
+			if !p.next.After(now) {
+				due = append(due, p)
+			}
+		}
+		for errors.Is(d.runDue(ctx, pool, due), ErrRunConflict) && ctx.Err() == nil {
+			d.waitRuns(ctx)
+		}
+		if ctx.Err() != nil {
+			return nil
+		}
+
+		now = time.Now()
+		for _, p := range due {
+			p.next = p.schedule.Next(now)
+			slog.Info("Next scheduled run", "pipeline", p.def.Name, "at", p.next)
+		}
+	}
+}
+
+func (d *Daemon) nextRun() time.Time {
+	var next time.Time
+	for _, p := range d.pipelines {
+		if !p.next.IsZero() && (next.IsZero() || p.next.Before(next)) {
+			next = p.next
+		}
+	}
+	return next
+}
+
+// waitRuns blocks until the runs in progress end or ctx is cancelled.
+func (d *Daemon) waitRuns(ctx context.Context) {
+	done := make(chan struct{})
+	go func() {
+		d.runs.Wait()
+		close(done)
+	}()
+	select {
+	case <-ctx.Done():
+	case <-done:
+	}
+}
+
+// runDue runs the due pipelines, returning ErrRunConflict when some are
+// already running.
+func (d *Daemon) runDue(ctx context.Context, pool *pgxpool.Pool, due []*scheduledPipeline) error {
+	settings := d.settings
+	settings.Releases = maps.Clone(settings.Releases)
+	if settings.Releases == nil {
+		settings.Releases = make(map[string]string)
+	}
+	selected := make([]Definition, 0, len(due))
+	for _, p := range due {
+		if p.def.ReleaseUrl == nil {
+			selected = append(selected, p.def)
+			continue
+		}
+
+		latest, err := internal.LatestRelease(ctx, p.def.ReleaseUrl(settings))
+		if err != nil {
+			slog.Error("Failed to check for a new release", "pipeline", p.def.Name, "error", err)
+			continue
+		}
+		if loaded := d.state.Releases[p.def.Name]; latest <= loaded {
+			slog.Info("Release already loaded, skipping", "pipeline", p.def.Name, "release", loaded)
+			continue
+		}
+		slog.Info("New release found", "pipeline", p.def.Name, "release", latest)
+		settings.Releases[p.def.Name] = latest
+		selected = append(selected, p.def)
+	}
+	if len(selected) == 0 {
+		return nil
+	}
+
+	results, err := d.runs.Execute(ctx, pool, settings, selected, "schedule")
+	if errors.Is(err, ErrRunConflict) {
+		slog.Info("Scheduled run waits for the run in progress", "error", err)
+		return err
+	}
+	if err != nil {
+		slog.Warn("Scheduled run not started", "error", err)
+		return err
+	}
+
+	for _, r := range results {
+		if r.Status != StatusSucceeded {
+			continue
+		}
+		d.state.LastRuns[r.Name] = time.Now()
+		if release, ok := settings.Releases[r.Name]; ok {
+			d.state.Releases[r.Name] = release
+		}
+	}
+	if err := d.saveState(); err != nil {
+		slog.Error("Failed to save serve state", "path", d.statePath, "error", err)
+	}
+	return nil
+}
+
+func (d *Daemon) loadState() error {
+	d.state = DaemonState{Releases: map[string]string{}, LastRuns: map[string]time.Time{}}
+	data, err := os.ReadFile(d.statePath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error reading serve state: %w", err)
+	}
+	if err := json.Unmarshal(data, &d.state); err != nil {
+		return fmt.Errorf("%w: error parsing serve state %s: %w", internal.ErrInvalidConfig, d.statePath, err)
+	}
+	if d.state.Releases == nil {
+		d.state.Releases = map[string]string{}
+	}
+	if d.state.LastRuns == nil {
+		d.state.LastRuns = map[string]time.Time{}
+	}
+	return nil
+}
+
+// saveState writes through a temporary file so a crash never leaves a
+// truncated state behind.
+func (d *Daemon) saveState() error {
+	data, err := json.MarshalIndent(d.state, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error encoding serve state: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(d.statePath), 0755); err != nil {
+		return fmt.Errorf("error creating state directory: %w", err)
+	}
+	tmp := d.statePath + ".tmp"
+	if err := os.WriteFile(tmp, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("error writing serve state: %w", err)
+	}
+	return os.Rename(tmp, d.statePath)
+}
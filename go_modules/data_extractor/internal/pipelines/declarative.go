@@ -0,0 +1,430 @@
+package pipelines
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/arko_tech_challenge/go_modules/data_extractor/internal"
+	"github.com/arko_tech_challenge/go_modules/data_extractor/internal/config"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type Row []any
+
+type RowEncoder struct{}
+
+func (e RowEncoder) Encode(ctx context.Context, v Row) ([]any, error) {
+	return v, nil
+}
+
+func FromConfig(p config.Pipeline) Definition {
+	def := Definition{
+		Name:             p.Name,
+		Description:      p.Description,
+		DefaultBatchSize: p.BatchSize,
+		DependsOn:        p.DependsOn,
+		Schedule:         p.Schedule,
+		Table:            configuredTable(p),
+		SourceUrls: func(settings Settings) []string {
+			if p.Source.Url == "" {
+				return nil
+			}
+			url, _ := settings.releaseSource(p.Source.Url, p.Source.StoragePath)
+			return []string{url}
+		},
+		Run: func(ctx context.Context, pool *pgxpool.Pool, settings Settings) (Stats, error) {
+			return RunConfiguredPipeline(ctx, pool, p, settings)
+		},
+	}
+	if p.WatchRelease {
+		def.ReleaseUrl = func(settings Settings) string {
+			return p.Source.Url
+		}
+	}
+	return def
+}
+
+// Catalog returns the built-in pipelines, with entries from the config file
+// replacing built-ins of the same name and new ones appended in file order.
+func Catalog(file *config.File) []Definition {
+	defs := make([]Definition, len(Registry))
+	copy(defs, Registry)
+	if file == nil {
+		return defs
+	}
+
+	for _, p := range file.Pipelines {
+		def := FromConfig(p)
+		replaced := false
+		for i := range defs {
+			if defs[i].Name == def.Name {
+				defs[i] = def
+				replaced = true
+				break
+			}
+		}
+		if !replaced {
+			defs = append(defs, def)
+		}
+	}
+	return defs
+}
+
+func RunConfiguredPipeline(ctx context.Context, pool *pgxpool.Pool, p config.Pipeline, settings Settings) (Stats, error) {
+	references, err := loadReferences(ctx, pool, p.Columns)
+	if err != nil {
+		return Stats{}, err
+	}
+	mapper := &rowMapper{columns: p.Columns, references: references}
+
+	var stats Stats
+	src, sourceUri, err := newConfiguredSource(ctx, p.Source, settings, mapper, &stats)
+	if err != nil {
+		return stats, err
+	}
+	ctx = internal.WithLineage(ctx, internal.Lineage{SourceUri: sourceUri})
+	src = internal.NewFilterSource(src, mapper.filter)
+
+	txTimeout, err := p.Timeout()
+	if err != nil {
+		return stats, err
+	}
+	retry, err := p.RetryPolicy()
+	if err != nil {
+		return stats, err
+	}
+
+	options := settings.runOptions()
+	if settings.Workers <= 0 {
+		options.Workers = p.Workers
+	}
+
+	releaseUrl, _ := settings.releaseSource(p.Source.Url, p.Source.StoragePath)
+	batcher := internal.NewFixedSizeBatcher[Row](settings.batchSize(p.BatchSize), src.ItemCount())
+	db := newSink[Row](pool, configuredTable(p), RowEncoder{}, settings, internal.PGOptions{
+		TxTimeout: txTimeout,
+		Retry:     retry,
+		WriteMode: internal.WriteMode(p.Table.WriteMode),
+		Release:   internal.ReleaseOf(releaseUrl),
+	})
+
+	runStats, err := RunPipeline(ctx, src, batcher, db, options)
+	stats.Merge(runStats)
+	return stats, err
+}
+
+// columnTypes are the Postgres types a column of each config type is loaded
+// as, and provisioned with unless it sets sql_type; doctor accepts any column
+// of the same family.
+var columnTypes = map[string]string{
+	config.TypeString:    "text",
+	config.TypeInt:       "bigint",
+	config.TypeFloat:     "double precision",
+	config.TypeDecimalBR: "numeric",
+}
+
+func configuredTable(p config.Pipeline) internal.TableSpec {
+	types := make([]string, len(p.Columns))
+	var notNull []string
+	references := make(map[string]internal.Reference)
+	for i, c := range p.Columns {
+		types[i] = columnTypes[c.Type]
+		if c.SQLType != "" {
+			types[i] = c.SQLType
+		}
+		if c.NotNull {
+			notNull = append(notNull, c.Name)
+		}
+		if c.References != nil {
+			references[c.Name] = internal.Reference{Table: c.References.Table, Column: c.References.Column}
+		}
+	}
+	keys := p.Table.ConflictColumns
+	if p.Table.ConflictColumn != "" {
+		keys = []string{p.Table.ConflictColumn}
+	}
+	var history internal.HistorySpec
+	if p.Table.History != nil {
+		history = internal.HistorySpec{Table: p.Table.History.Table, Columns: p.Table.History.Columns}
+	}
+	return internal.TableSpec{
+		Name:            p.Table.Name,
+		Columns:         p.ColumnNames(),
+		Types:           types,
+		NotNull:         notNull,
+		References:      references,
+		ConflictMode:    internal.ConflictMode(p.Table.ConflictMode),
+		ConflictColumns: keys,
+		Duplicates:      internal.DuplicatePolicy(p.Table.Duplicates),
+		UpdateColumns:   p.Table.UpdateColumns,
+		CoalesceColumns: p.Table.CoalesceColumns,
+		Sync: internal.SyncPolicy{
+			Mode:              internal.SyncMode(p.Table.Sync),
+			TombstoneColumn:   p.Table.TombstoneColumn,
+			MaxRemovedPercent: p.Table.MaxRemovedPercent,
+		},
+		History: history,
+	}
+}
+
+// newConfiguredSource opens source and returns it with its URI for lineage.
+func newConfiguredSource(ctx context.Context, source config.Source, settings Settings, mapper *rowMapper, stats *Stats) (internal.Source[Row], string, error) {
+	comma := []rune(source.Comma)[0]
+
+	switch source.Type {
+	case config.SourceAPI:
+		return internal.NewAPISource(source.Url, mapper.mapJSON), source.Url, nil
+	case config.SourceCSV:
+		src, err := openCSVSource(source.Path, comma, source.Header, mapper)
+		return src, source.Path, err
+	case config.SourceZip:
+		url, storagePath := settings.releaseSource(source.Url, source.StoragePath)
+		name := strings.TrimSuffix(filepath.Base(url), filepath.Ext(url))
+		zipPath := filepath.Join(storagePath, name+".zip")
+		extractPath := filepath.Join(storagePath, name)
+
+		downloader := internal.NewHTTPDownloader()
+		stageStart := time.Now()
+		if err := downloader.Download(ctx, url, zipPath); err != nil {
+			return nil, "", fmt.Errorf("failed to download %s: %w", url, err)
+		}
+		stats.AddStage("download", time.Since(stageStart))
+		stats.BytesDownloaded = downloader.BytesDownloaded()
+
+		stageStart = time.Now()
+		if err := downloader.Extract(ctx, zipPath, extractPath); err != nil {
+			return nil, "", fmt.Errorf("failed to extract %s: %w", zipPath, err)
+		}
+		stats.AddStage("extract", time.Since(stageStart))
+
+		member, err := findMember(extractPath, source.Member)
+		if err != nil {
+			return nil, "", err
+		}
+		src, err := openCSVSource(member, comma, source.Header, mapper)
+		return src, url + "!/" + filepath.Base(member), err
+	}
+	return nil, "", fmt.Errorf("%w: unknown source type %q", internal.ErrInvalidConfig, source.Type)
+}
+
+func openCSVSource(path string, comma rune, header bool, mapper *rowMapper) (internal.Source[Row], error) {
+	itemCount, err := countRecords(path, header)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open CSV file: %w", err)
+	}
+	return internal.NewCSVSource(file, comma, header, mapper.mapRecord, itemCount), nil
+}
+
+func findMember(extractPath, pattern string) (string, error) {
+	files, err := os.ReadDir(extractPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to read extract directory: %w", err)
+	}
+
+	for _, f := range files {
+		if f.IsDir() {
+			continue
+		}
+		if pattern == "" {
+			return filepath.Join(extractPath, f.Name()), nil
+		}
+		if ok, _ := filepath.Match(pattern, f.Name()); ok {
+			return filepath.Join(extractPath, f.Name()), nil
+		}
+	}
+	return "", fmt.Errorf("%w: no file matching %q in %s", internal.ErrSchemaMismatch, pattern, extractPath)
+}
+
+func countRecords(path string, header bool) (int, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, fmt.Errorf("failed to open %s: %w", path, err)
+	}
+	defer file.Close()
+
+	count := 0
+	reader := bufio.NewReaderSize(file, 1024*1024)
+	buf := make([]byte, 1024*1024)
+	for {
+		n, err := reader.Read(buf)
+		count += bytes.Count(buf[:n], []byte{'\n'})
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return 0, fmt.Errorf("failed to count records in %s: %w", path, err)
+		}
+	}
+	if header && count > 0 {
+		count--
+	}
+	return count, nil
+}
+
+func loadReferences(ctx context.Context, pool *pgxpool.Pool, columns []config.Column) (map[string]map[string]bool, error) {
+	references := make(map[string]map[string]bool)
+	for _, c := range columns {
+		if c.References == nil {
+			continue
+		}
+
+		rows, err := pool.Query(ctx, fmt.Sprintf("SELECT %s::text FROM %s", c.References.Column, c.References.Table))
+		if err != nil {
+			return nil, fmt.Errorf("failed to query %s.%s: %w", c.References.Table, c.References.Column, err)
+		}
+
+		valid := make(map[string]bool)
+		for rows.Next() {
+			var key string
+			if err := rows.Scan(&key); err != nil {
+				rows.Close()
+				return nil, fmt.Errorf("failed to scan %s.%s: %w", c.References.Table, c.References.Column, err)
+			}
+			valid[key] = true
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, fmt.Errorf("error iterating %s rows: %w", c.References.Table, err)
+		}
+		references[c.Name] = valid
+	}
+	return references, nil
+}
+
+type rowMapper struct {
+	columns    []config.Column
+	references map[string]map[string]bool
+}
+
+func (m *rowMapper) mapRecord(record []string) (Row, error) {
+	row := make(Row, len(m.columns))
+	for i, c := range m.columns {
+		if *c.Index >= len(record) {
+			return nil, fmt.Errorf("%w: column %s: record has %d fields, want index %d", internal.ErrInvalidData, c.Name, len(record), *c.Index)
+		}
+		v, err := convertValue(c, cleanValue(record[*c.Index]))
+		if err != nil {
+			return nil, err
+		}
+		row[i] = v
+	}
+	return row, nil
+}
+
+func (m *rowMapper) mapJSON(data []byte) ([]Row, bool, error) {
+	var items []map[string]any
+	if err := json.Unmarshal(data, &items); err != nil {
+		return nil, false, fmt.Errorf("%w: failed to unmarshal response: %w", internal.ErrSchemaMismatch, err)
+	}
+
+	rows := make([]Row, 0, len(items))
+	for _, item := range items {
+		row := make(Row, len(m.columns))
+		for i, c := range m.columns {
+			v, err := convertValue(c, lookupField(item, c.Field))
+			if err != nil {
+				return nil, false, err
+			}
+			row[i] = v
+		}
+		rows = append(rows, row)
+	}
+	return rows, false, nil
+}
+
+func (m *rowMapper) filter(row Row) error {
+	for i, c := range m.columns {
+		valid, ok := m.references[c.Name]
+		if ok && !valid[fmt.Sprint(row[i])] {
+			return internal.Reject(fmt.Sprintf("%s not in %s", c.Name, c.References.Table))
+		}
+	}
+	return nil
+}
+
+func lookupField(item map[string]any, path string) any {
+	var current any = item
+	for _, key := range strings.Split(path, ".") {
+		obj, ok := current.(map[string]any)
+		if !ok {
+			return nil
+		}
+		current = obj[key]
+	}
+	return current
+}
+
+func cleanValue(val string) string {
+	val = strings.TrimSpace(val)
+	if !utf8.ValidString(val) {
+		val = strings.Map(func(r rune) rune {
+			if r == utf8.RuneError {
+				return -1
+			}
+			return r
+		}, val)
+	}
+	return val
+}
+
+func convertValue(c config.Column, raw any) (any, error) {
+	if raw == nil {
+		return nil, nil
+	}
+
+	switch v := raw.(type) {
+	case float64:
+		switch c.Type {
+		case config.TypeInt:
+			return int64(v), nil
+		case config.TypeFloat, config.TypeDecimalBR:
+			return v, nil
+		default:
+			return strconv.FormatFloat(v, 'f', -1, 64), nil
+		}
+	case string:
+		switch c.Type {
+		case config.TypeInt:
+			n, err := strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("%w: column %s: failed to parse int %q: %w", internal.ErrInvalidData, c.Name, v, err)
+			}
+			return n, nil
+		case config.TypeFloat:
+			f, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				return nil, fmt.Errorf("%w: column %s: failed to parse float %q: %w", internal.ErrInvalidData, c.Name, v, err)
+			}
+			return f, nil
+		case config.TypeDecimalBR:
+			normalized := strings.ReplaceAll(strings.ReplaceAll(v, ".", ""), ",", ".")
+			f, err := strconv.ParseFloat(normalized, 64)
+			if err != nil {
+				return nil, fmt.Errorf("%w: column %s: failed to parse decimal %q: %w", internal.ErrInvalidData, c.Name, v, err)
+			}
+			return f, nil
+		default:
+			return v, nil
+		}
+	default:
+		if c.Type == config.TypeString {
+			return fmt.Sprint(v), nil
+		}
+		return nil, fmt.Errorf("%w: column %s: cannot convert %T to %s", internal.ErrInvalidData, c.Name, raw, c.Type)
+	}
+}
@@ -10,6 +10,38 @@ import (
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
+var statesTable = internal.TableSpec{
+	Name:            "state",
+	Columns:         []string{"id", "name", "acronym"},
+	Types:           []string{"bigint", "varchar(255)", "varchar(2)"},
+	NotNull:         []string{"id", "name", "acronym"},
+	ConflictMode:    internal.ConflictModeUpdate,
+	ConflictColumns: []string{"id"},
+	UpdateColumns:   []string{"name", "acronym"},
+}
+
+var citiesTable = internal.TableSpec{
+	Name:            "city",
+	Columns:         []string{"id", "name", "state_id"},
+	Types:           []string{"bigint", "varchar(255)", "bigint"},
+	NotNull:         []string{"id", "name", "state_id"},
+	References:      map[string]internal.Reference{"state_id": {Table: "state", Column: "id"}},
+	UpdateColumns:   []string{"name", "state_id"},
+	ConflictMode:    internal.ConflictModeUpdate,
+	ConflictColumns: []string{"id"},
+}
+
+var districtsTable = internal.TableSpec{
+	Name:            "district",
+	Columns:         []string{"id", "name", "city_id"},
+	Types:           []string{"bigint", "varchar(255)", "bigint"},
+	NotNull:         []string{"id", "name", "city_id"},
+	References:      map[string]internal.Reference{"city_id": {Table: "city", Column: "id"}},
+	UpdateColumns:   []string{"name", "city_id"},
+	ConflictMode:    internal.ConflictModeUpdate,
+	ConflictColumns: []string{"id"},
+}
+
 type State struct {
 	ID      int    `json:"id"`
 	Name    string `json:"nome"`
@@ -32,7 +64,7 @@ func (e *StateEncoder) Encode(ctx context.Context, v State) ([]any, error) {
 	return []any{v.ID, v.Name, v.Acronym}, nil
 }
 
-func RunStatesPipeline(ctx context.Context, pool *pgxpool.Pool, apiUrl string, batchSize int) error {
+func RunStatesPipeline(ctx context.Context, pool *pgxpool.Pool, apiUrl string, settings Settings) (Stats, error) {
 	src := internal.NewAPISource(apiUrl, func(data []byte) ([]State, bool, error) {
 		var states []State
 		if err := json.Unmarshal(data, &states); err != nil {
@@ -41,20 +73,14 @@ func RunStatesPipeline(ctx context.Context, pool *pgxpool.Pool, apiUrl string, b
 		return states, false, nil
 	})
 
-	batcher := internal.NewFixedSizeBatcher[State](batchSize, src.ItemCount())
+	batcher := internal.NewFixedSizeBatcher[State](settings.BatchSize, src.ItemCount())
 	encoder := NewStateEncoder(pool)
-	tableSpec := internal.TableSpec{
-		Name:           "state",
-		Columns:        []string{"id", "name", "acronym"},
-		ConflictMode:   internal.ConflictModeUpdate,
-		ConflictColumn: "id",
-		UpdateColumns:  []string{"name", "acronym"},
-	}
-	db := internal.NewPostgresRepository(pool, tableSpec, encoder, internal.PGOptions{
+	db := newSink(pool, statesTable, encoder, settings, internal.PGOptions{
 		TxTimeout: 10 * time.Second,
 	})
 
-	return RunPipeline(ctx, src, batcher, db)
+	ctx = internal.WithLineage(ctx, internal.Lineage{SourceUri: apiUrl})
+	return RunPipeline(ctx, src, batcher, db, settings.runOptions())
 }
 
 type City struct {
@@ -97,10 +123,10 @@ type CityResponse struct {
 	} `json:"microrregiao"`
 }
 
-func RunCitiesPipeline(ctx context.Context, pool *pgxpool.Pool, apiUrl string, batchSize int) error {
+func RunCitiesPipeline(ctx context.Context, pool *pgxpool.Pool, apiUrl string, settings Settings) (Stats, error) {
 	rows, err := pool.Query(ctx, "SELECT id FROM state")
 	if err != nil {
-		return fmt.Errorf("failed to query states: %w", err)
+		return Stats{}, fmt.Errorf("failed to query states: %w", err)
 	}
 	defer rows.Close()
 
@@ -108,13 +134,13 @@ func RunCitiesPipeline(ctx context.Context, pool *pgxpool.Pool, apiUrl string, b
 	for rows.Next() {
 		var id int
 		if err := rows.Scan(&id); err != nil {
-			return fmt.Errorf("failed to scan state id: %w", err)
+			return Stats{}, fmt.Errorf("failed to scan state id: %w", err)
 		}
 		validStateIDs[id] = true
 	}
 
 	if err := rows.Err(); err != nil {
-		return fmt.Errorf("error iterating state rows: %w", err)
+		return Stats{}, fmt.Errorf("error iterating state rows: %w", err)
 	}
 
 	src := internal.NewAPISource(apiUrl, func(data []byte) ([]City, bool, error) {
@@ -125,32 +151,29 @@ func RunCitiesPipeline(ctx context.Context, pool *pgxpool.Pool, apiUrl string, b
 
 		cities := make([]City, 0, len(citiesResponse))
 		for _, city := range citiesResponse {
-			stateID := city.MicroRegion.Mesoregion.UF.ID
-			if validStateIDs[stateID] {
-				cities = append(cities, City{
-					ID:      city.ID,
-					Name:    city.Name,
-					StateID: stateID,
-				})
-			}
+			cities = append(cities, City{
+				ID:      city.ID,
+				Name:    city.Name,
+				StateID: city.MicroRegion.Mesoregion.UF.ID,
+			})
 		}
 		return cities, false, nil
 	})
+	src = internal.NewFilterSource(src, func(c City) error {
+		if !validStateIDs[c.StateID] {
+			return internal.Reject("state_id not in state")
+		}
+		return nil
+	})
 
-	batcher := internal.NewFixedSizeBatcher[City](batchSize, src.ItemCount())
+	batcher := internal.NewFixedSizeBatcher[City](settings.BatchSize, src.ItemCount())
 	encoder := NewCityEncoder(pool)
-	tableSpec := internal.TableSpec{
-		Name:           "city",
-		Columns:        []string{"id", "name", "state_id"},
-		UpdateColumns:  []string{"name", "state_id"},
-		ConflictMode:   internal.ConflictModeUpdate,
-		ConflictColumn: "id",
-	}
-	db := internal.NewPostgresRepository(pool, tableSpec, encoder, internal.PGOptions{
+	db := newSink(pool, citiesTable, encoder, settings, internal.PGOptions{
 		TxTimeout: 10 * time.Second,
 	})
 
-	return RunPipeline(ctx, src, batcher, db)
+	ctx = internal.WithLineage(ctx, internal.Lineage{SourceUri: apiUrl})
+	return RunPipeline(ctx, src, batcher, db, settings.runOptions())
 }
 
 type District struct {
@@ -183,10 +206,10 @@ type DistrictResponse struct {
 	} `json:"municipio"`
 }
 
-func RunDistrictsPipeline(ctx context.Context, pool *pgxpool.Pool, apiUrl string, batchSize int) error {
+func RunDistrictsPipeline(ctx context.Context, pool *pgxpool.Pool, apiUrl string, settings Settings) (Stats, error) {
 	rows, err := pool.Query(ctx, "SELECT id FROM city")
 	if err != nil {
-		return fmt.Errorf("failed to query cities: %w", err)
+		return Stats{}, fmt.Errorf("failed to query cities: %w", err)
 	}
 	defer rows.Close()
 
@@ -194,13 +217,13 @@ func RunDistrictsPipeline(ctx context.Context, pool *pgxpool.Pool, apiUrl string
 	for rows.Next() {
 		var id int
 		if err := rows.Scan(&id); err != nil {
-			return fmt.Errorf("failed to scan city id: %w", err)
+			return Stats{}, fmt.Errorf("failed to scan city id: %w", err)
 		}
 		validCityIDs[id] = true
 	}
 
 	if err := rows.Err(); err != nil {
-		return fmt.Errorf("error iterating city rows: %w", err)
+		return Stats{}, fmt.Errorf("error iterating city rows: %w", err)
 	}
 
 	src := internal.NewAPISource(apiUrl, func(data []byte) ([]District, bool, error) {
@@ -211,29 +234,27 @@ func RunDistrictsPipeline(ctx context.Context, pool *pgxpool.Pool, apiUrl string
 
 		districts := make([]District, 0, len(districtsResponse))
 		for _, district := range districtsResponse {
-			if validCityIDs[district.City.ID] {
-				districts = append(districts, District{
-					ID:     district.ID,
-					Name:   district.Name,
-					CityID: district.City.ID,
-				})
-			}
+			districts = append(districts, District{
+				ID:     district.ID,
+				Name:   district.Name,
+				CityID: district.City.ID,
+			})
 		}
 		return districts, false, nil
 	})
+	src = internal.NewFilterSource(src, func(d District) error {
+		if !validCityIDs[d.CityID] {
+			return internal.Reject("city_id not in city")
+		}
+		return nil
+	})
 
-	batcher := internal.NewFixedSizeBatcher[District](batchSize, src.ItemCount())
+	batcher := internal.NewFixedSizeBatcher[District](settings.BatchSize, src.ItemCount())
 	encoder := NewDistrictEncoder(pool)
-	tableSpec := internal.TableSpec{
-		Name:           "district",
-		Columns:        []string{"id", "name", "city_id"},
-		UpdateColumns:  []string{"name", "city_id"},
-		ConflictMode:   internal.ConflictModeUpdate,
-		ConflictColumn: "id",
-	}
-	db := internal.NewPostgresRepository(pool, tableSpec, encoder, internal.PGOptions{
+	db := newSink(pool, districtsTable, encoder, settings, internal.PGOptions{
 		TxTimeout: 10 * time.Second,
 	})
 
-	return RunPipeline(ctx, src, batcher, db)
+	ctx = internal.WithLineage(ctx, internal.Lineage{SourceUri: apiUrl})
+	return RunPipeline(ctx, src, batcher, db, settings.runOptions())
 }
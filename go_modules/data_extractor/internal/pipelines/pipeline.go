@@ -5,103 +5,258 @@ import (
 	"errors"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"runtime"
 	"sync"
 	"time"
 
 	"github.com/arko_tech_challenge/go_modules/data_extractor/internal"
+	"github.com/arko_tech_challenge/go_modules/data_extractor/internal/logging"
+	"github.com/arko_tech_challenge/go_modules/data_extractor/internal/metrics"
 )
 
-func RunPipeline[T any](ctx context.Context, src internal.Source[T], batcher internal.Batcher[T], db internal.Sink[T]) error {
+type RunOptions struct {
+	Workers int
+	// DrainTimeout bounds how long in-flight WriteBatch calls may keep running
+	// after ctx is cancelled before their own context is cancelled too.
+	DrainTimeout time.Duration
+	Progress     *Progress
+	// Pipeline labels the metrics recorded by RunPipeline.
+	Pipeline string
+}
+
+func (o RunOptions) Default() RunOptions {
+	if o.Workers <= 0 {
+		o.Workers = 5
+	}
+	if o.DrainTimeout <= 0 {
+		o.DrainTimeout = 30 * time.Second
+	}
+	return o
+}
+
+// InterruptedError is returned by RunPipeline when ctx is cancelled before
+// the source is exhausted. Batches is the number of batches fully written.
+type InterruptedError struct {
+	Batches int
+	Cause   error
+}
+
+func (e *InterruptedError) Error() string {
+	return fmt.Sprintf("interrupted at batch %d: %v", e.Batches, e.Cause)
+}
+
+func (e *InterruptedError) Unwrap() error {
+	return e.Cause
+}
+
+// progressLogInterval is how often a running pipeline logs its progress.
+const progressLogInterval = 10 * time.Second
+
+type numberedBatch[T any] struct {
+	number int
+	rows   []T
+}
+
+func RunPipeline[T any](ctx context.Context, src internal.Source[T], batcher internal.Batcher[T], db internal.Sink[T], options RunOptions) (Stats, error) {
 	defer func() { _ = src.Close() }()
+	options = options.Default()
+	progress := options.Progress
+	progress.attach(batcher)
+	name := options.Pipeline
+	rowsRead := metrics.RowsRead.WithLabelValues(name)
+	writeDuration := metrics.WriteDuration.WithLabelValues(name)
+	queueDepth := metrics.QueueDepth.WithLabelValues(name)
 
-	ticker := time.NewTicker(200 * time.Millisecond)
+	runCtx, cancelRun := context.WithCancel(ctx)
+	defer cancelRun()
+	producerDone := make(chan struct{})
+	// doneChan is closed once every worker has exited.
+	doneChan := make(chan struct{})
+
+	var stats Stats
+	var written writeStats
+	var readTime time.Duration
+	start := time.Now()
+	if b, ok := db.(internal.Beginner); ok {
+		err := b.Begin(ctx)
+		stats.AddStage("begin", time.Since(start))
+		if err != nil {
+			return stats, fmt.Errorf("error beginning sink: %w", err)
+		}
+	}
+	finish := func(err error) (Stats, error) {
+		cancelRun()
+		<-producerDone
+		// Workers stop at their next batch, but one still in WriteBatch keeps
+		// going on writeCtx; End must not run, nor counts be read, before it
+		// is done.
+		<-doneChan
+		// Ending restores the sink after an interruption too.
+		if e, ok := db.(internal.Ender); ok {
+			endStart := time.Now()
+			endErr := e.End(context.WithoutCancel(ctx), err)
+			stats.AddStage("end", time.Since(endStart))
+			if endErr != nil {
+				err = errors.Join(err, fmt.Errorf("error ending sink: %w", endErr))
+			}
+		}
+		stats.AddStage("read", readTime)
+		stats.AddStage("write", time.Duration(written.nanos.Load()))
+		stats.AddStage("pipeline", time.Since(start))
+		stats.BatchesWritten = written.batches.Load()
+		stats.RowsWritten = written.rows.Load()
+		if c, ok := src.(internal.ByteCounter); ok {
+			stats.BytesRead = c.BytesRead()
+		}
+		if c, ok := db.(internal.WriteCounter); ok {
+			counts := c.WriteCounts()
+			stats.Inserted = counts.Inserted
+			stats.Updated = counts.Updated
+			stats.DeadLettered = counts.DeadLettered
+			stats.Removed = counts.Removed
+			stats.Duplicates = counts.Duplicates
+		}
+		return stats, err
+	}
+
+	batchChan := make(chan numberedBatch[T], runtime.NumCPU())
+
+	ticker := time.NewTicker(time.Second)
 	defer ticker.Stop()
+	stopProgress := make(chan struct{})
+	defer close(stopProgress)
 
 	go func() {
+		lastLog := time.Now()
 		for {
 			select {
 			case <-ctx.Done():
 				return
+			case <-stopProgress:
+				return
 			case <-ticker.C:
-				log.Println(batcher.Progress())
+				queueDepth.Set(float64(len(batchChan)))
+				if time.Since(lastLog) >= progressLogInterval {
+					lastLog = time.Now()
+					slog.InfoContext(ctx, "Progress", "batches_written", batcher.Upserted(), "total_batches", batcher.Total(), "queued", len(batchChan))
+				}
 			}
 		}
 	}()
 
-	batchChan := make(chan []T, runtime.NumCPU())
+	writeCtx, cancelWrites := context.WithCancel(context.WithoutCancel(ctx))
+	defer cancelWrites()
+	stopDrain := context.AfterFunc(ctx, func() {
+		time.AfterFunc(options.DrainTimeout, cancelWrites)
+	})
+	defer stopDrain()
+
 	errChan := make(chan error, 1)
-	doneChan := make(chan struct{})
 
-	numWorkers := 5
+	reportErr := func(err error) {
+		select {
+		case errChan <- err:
+		default:
+		}
+	}
 
 	var wg sync.WaitGroup
-	for i := 0; i < numWorkers; i++ {
+	for i := 0; i < options.Workers; i++ {
 		wg.Add(1)
 		go func(workerID int) {
 			defer wg.Done()
-			for batch := range batchChan {
-				select {
-				case <-ctx.Done():
+			for b := range batchChan {
+				if runCtx.Err() != nil {
 					return
-				default:
-					if err := db.WriteBatch(ctx, batch); err != nil {
-						select {
-						case errChan <- fmt.Errorf("worker %d error: %w", workerID, err):
-						default:
-						}
-						return
-					}
-					batcher.AddUpsertedBatch()
 				}
+				batchCtx := logging.With(writeCtx, "batch", b.number)
+				writeStart := time.Now()
+				err := db.WriteBatch(batchCtx, b.rows)
+				elapsed := time.Since(writeStart)
+				written.nanos.Add(int64(elapsed))
+				writeDuration.Observe(elapsed.Seconds())
+				if err != nil {
+					metrics.WriteErrors.WithLabelValues(name).Inc()
+					slog.ErrorContext(batchCtx, "Write batch failed", "worker", workerID, "rows", len(b.rows), "error", err)
+					reportErr(fmt.Errorf("worker %d, batch %d: %w", workerID, b.number, err))
+					return
+				}
+				slog.DebugContext(batchCtx, "Batch written", "worker", workerID, "rows", len(b.rows), "seconds", elapsed.Seconds())
+				written.batches.Add(1)
+				written.rows.Add(int64(len(b.rows)))
+				metrics.BatchesWritten.WithLabelValues(name).Inc()
+				metrics.RowsWritten.WithLabelValues(name).Add(float64(len(b.rows)))
+				progress.written(len(b.rows))
+				batcher.AddUpsertedBatch()
 			}
 		}(i)
 	}
 
 	go func() {
+		defer close(producerDone)
 		defer close(batchChan)
+		batchNumber := 0
 
-		for src.HasNext(ctx) {
+		for src.HasNext(runCtx) {
 			select {
-			case <-ctx.Done():
+			case <-runCtx.Done():
 				return
 			default:
 			}
 
-			in, err := src.Next(ctx)
+			readStart := time.Now()
+			in, err := src.Next(runCtx)
+			readTime += time.Since(readStart)
 			if errors.Is(err, io.EOF) {
 				break
 			}
+			stats.RowsRead++
+			progress.read()
+			rowsRead.Inc()
+			if errors.Is(err, internal.ErrSkipRecord) {
+				reason := "skipped"
+				var rejected *internal.RejectError
+				if errors.As(err, &rejected) {
+					reason = rejected.Reason
+				}
+				stats.reject(reason)
+				progress.rejected()
+				metrics.RowsRejected.WithLabelValues(name, reason).Inc()
+				continue
+			}
 			if err != nil {
-				errChan <- fmt.Errorf("error reading from source: %w", err)
+				reportErr(fmt.Errorf("error reading from source: %w", err))
 				return
 			}
+			stats.RowsMapped++
+			progress.mapped()
 
-			ready, batch, err := batcher.Push(ctx, in)
+			ready, batch, err := batcher.Push(runCtx, in)
 			if err != nil {
-				errChan <- fmt.Errorf("error pushing to batcher: %w", err)
+				reportErr(fmt.Errorf("error pushing to batcher: %w", err))
 				return
 			}
 			if ready {
+				batchNumber++
 				select {
-				case <-ctx.Done():
+				case <-runCtx.Done():
 					return
-				case batchChan <- batch:
+				case batchChan <- numberedBatch[T]{number: batchNumber, rows: batch}:
 				}
 			}
 		}
 
-		remaining, err := batcher.Flush(ctx)
+		remaining, err := batcher.Flush(runCtx)
 		if err != nil {
-			errChan <- fmt.Errorf("error flushing batcher: %w", err)
+			reportErr(fmt.Errorf("error flushing batcher: %w", err))
 			return
 		}
 		if len(remaining) > 0 {
 			select {
-			case <-ctx.Done():
+			case <-runCtx.Done():
 				return
-			case batchChan <- remaining:
+			case batchChan <- numberedBatch[T]{number: batchNumber + 1, rows: remaining}:
 			}
 		}
 	}()
@@ -113,11 +268,28 @@ func RunPipeline[T any](ctx context.Context, src internal.Source[T], batcher int
 
 	select {
 	case err := <-errChan:
-		return err
+		return finish(err)
 	case <-doneChan:
-		log.Println(batcher.Progress())
-		return nil
+		if ctx.Err() != nil {
+			return finish(&InterruptedError{Batches: batcher.Upserted(), Cause: context.Cause(ctx)})
+		}
+		select {
+		case err := <-errChan:
+			return finish(err)
+		default:
+		}
+		if f, ok := db.(internal.Finisher); ok {
+			finishStart := time.Now()
+			err := f.Finish(ctx)
+			stats.AddStage("finish", time.Since(finishStart))
+			if err != nil {
+				return finish(fmt.Errorf("error finishing sink: %w", err))
+			}
+		}
+		return finish(nil)
 	case <-ctx.Done():
-		return ctx.Err()
+		slog.WarnContext(ctx, "Interrupted, draining in-flight batches", "drain_timeout", options.DrainTimeout.String())
+		<-doneChan
+		return finish(&InterruptedError{Batches: batcher.Upserted(), Cause: context.Cause(ctx)})
 	}
 }
@@ -0,0 +1,151 @@
+package pipelines
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	StatusPending Status = "pending"
+	StatusRunning Status = "running"
+)
+
+// batchCounter is the part of internal.Batcher that Progress reads.
+type batchCounter interface {
+	Upserted() int
+	Total() int
+}
+
+// Progress is the live state of one pipeline in a Scheduler run. RunPipeline
+// updates it through RunOptions; a nil *Progress ignores every update.
+type Progress struct {
+	mu       sync.Mutex
+	status   Status
+	err      error
+	started  time.Time
+	finished time.Time
+	batcher  batchCounter
+	stats    *Stats
+
+	rowsRead     atomic.Int64
+	rowsMapped   atomic.Int64
+	rowsRejected atomic.Int64
+	rowsWritten  atomic.Int64
+}
+
+type PipelineProgress struct {
+	Name           string     `json:"name"`
+	Status         Status     `json:"status"`
+	Error          string     `json:"error,omitempty"`
+	StartedAt      *time.Time `json:"started_at,omitempty"`
+	Seconds        float64    `json:"seconds"`
+	RowsRead       int64      `json:"rows_read"`
+	RowsMapped     int64      `json:"rows_mapped"`
+	RowsRejected   int64      `json:"rows_rejected"`
+	RowsWritten    int64      `json:"rows_written"`
+	BatchesWritten int        `json:"batches_written"`
+	TotalBatches   int        `json:"total_batches"`
+	Percent        float64    `json:"percent"`
+	Stats          *Stats     `json:"stats,omitempty"`
+}
+
+func newProgress() *Progress {
+	return &Progress{status: StatusPending}
+}
+
+func (p *Progress) start() {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.status = StatusRunning
+	p.started = time.Now()
+}
+
+func (p *Progress) finish(result Result) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.status = result.Status
+	p.err = result.Err
+	p.finished = time.Now()
+	if result.Status != StatusSkipped {
+		stats := result.Stats
+		p.stats = &stats
+	}
+}
+
+func (p *Progress) attach(batcher batchCounter) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.batcher = batcher
+}
+
+func (p *Progress) read() {
+	if p != nil {
+		p.rowsRead.Add(1)
+	}
+}
+
+func (p *Progress) mapped() {
+	if p != nil {
+		p.rowsMapped.Add(1)
+	}
+}
+
+func (p *Progress) rejected() {
+	if p != nil {
+		p.rowsRejected.Add(1)
+	}
+}
+
+func (p *Progress) written(rows int) {
+	if p != nil {
+		p.rowsWritten.Add(int64(rows))
+	}
+}
+
+func (p *Progress) Snapshot(name string) PipelineProgress {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	s := PipelineProgress{
+		Name:         name,
+		Status:       p.status,
+		RowsRead:     p.rowsRead.Load(),
+		RowsMapped:   p.rowsMapped.Load(),
+		RowsRejected: p.rowsRejected.Load(),
+		RowsWritten:  p.rowsWritten.Load(),
+		Stats:        p.stats,
+	}
+	if p.err != nil {
+		s.Error = p.err.Error()
+	}
+	if !p.started.IsZero() {
+		started := p.started
+		s.StartedAt = &started
+		end := p.finished
+		if end.IsZero() {
+			end = time.Now()
+		}
+		s.Seconds = end.Sub(started).Seconds()
+	}
+	if p.batcher != nil {
+		s.BatchesWritten = p.batcher.Upserted()
+		s.TotalBatches = p.batcher.Total()
+	}
+	switch {
+	case p.status == StatusSucceeded:
+		s.Percent = 100
+	case s.TotalBatches > 0:
+		s.Percent = min(100, float64(s.BatchesWritten)/float64(s.TotalBatches)*100)
+	}
+	return s
+}
@@ -0,0 +1,248 @@
+package pipelines
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/arko_tech_challenge/go_modules/data_extractor/internal"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type Settings struct {
+	LocationUrl        string
+	CompanyZipUrl      string
+	CompanyStoragePath string
+	BatchSize          int
+	Workers            int
+	DrainTimeout       time.Duration
+	// DryRun, when set, replaces every Postgres sink with a DiffSink that
+	// accumulates its would-be changes here instead of writing.
+	DryRun *internal.DiffReport
+	// Release, when set, loads that YYYY-MM Receita release instead of the
+	// one in the configured URL, into its own storage subdirectory.
+	Release string
+	// Releases overrides Release for the pipelines it names, so serve loads
+	// the newest release of each.
+	Releases map[string]string
+	// WriteMode, when set, overrides the write mode of every Postgres sink.
+	WriteMode internal.WriteMode
+	// Retry overrides the non-zero fields of the RetryPolicy of every
+	// Postgres sink.
+	Retry internal.RetryPolicy
+	// DeadLetters receives the rows Postgres sinks reject, see
+	// internal.PGOptions.
+	DeadLetters internal.DeadLetterWriter
+	// Sync overrides the SyncPolicy of the table of a pipeline, by name.
+	Sync map[string]internal.SyncPolicy
+	// History writes the history table of every table that declares one.
+	History bool
+	// Lineage stamps every row with the lineage columns, see
+	// internal.Lineage.
+	Lineage bool
+	// Swap loads the table of the pipelines it names blue/green, see
+	// internal.PGOptions.Swap, failing when the new table has more than
+	// SwapMaxShrinkPercent fewer rows than the live one.
+	Swap                 map[string]bool
+	SwapMaxShrinkPercent float64
+	// BulkLoad loads the table of the pipelines it names in bulk, see
+	// internal.PGOptions.BulkLoad.
+	BulkLoad map[string]bool
+	// SessionParams are set in the write transactions of every Postgres
+	// sink, see internal.PGOptions.SessionParams.
+	SessionParams map[string]string
+	// Targets are written next to Postgres by every pipeline, through an
+	// internal.FanOut. They are skipped by dry runs.
+	Targets []TargetFactory
+
+	// pipeline and progress are set by the Scheduler for the pipeline being
+	// run.
+	pipeline string
+	progress *Progress
+}
+
+func (s Settings) batchSize(defaultValue int) int {
+	if s.BatchSize > 0 {
+		return s.BatchSize
+	}
+	return defaultValue
+}
+
+func (s Settings) withBatchSize(defaultValue int) Settings {
+	s.BatchSize = s.batchSize(defaultValue)
+	return s
+}
+
+func (s Settings) runOptions() RunOptions {
+	return RunOptions{Workers: s.Workers, DrainTimeout: s.DrainTimeout, Progress: s.progress, Pipeline: s.pipeline}
+}
+
+func (s Settings) releaseSource(url, storagePath string) (string, string) {
+	release := s.Release
+	if r, ok := s.Releases[s.pipeline]; ok {
+		release = r
+	}
+	if release == "" {
+		return url, storagePath
+	}
+	return internal.WithRelease(url, release), filepath.Join(storagePath, release)
+}
+
+// TargetFactory builds an extra sink for each pipeline run, given the
+// pipeline name and the table its Postgres sink writes.
+type TargetFactory struct {
+	Name        string
+	Policy      internal.FailurePolicy
+	Concurrency int
+	New         func(pipeline string, spec internal.TableSpec) internal.Sink[any]
+}
+
+func newSink[T any](pool *pgxpool.Pool, spec internal.TableSpec, encoder internal.DBEncoder[T], settings Settings, options internal.PGOptions) internal.Sink[T] {
+	if settings.DryRun != nil {
+		return internal.NewDiffSink(pool, spec, encoder, settings.DryRun)
+	}
+	db := newPostgresSink(pool, spec, encoder, settings, options)
+	if len(settings.Targets) == 0 {
+		return db
+	}
+	targets := []internal.Target[T]{{Name: "postgres", Sink: db}}
+	for _, factory := range settings.Targets {
+		targets = append(targets, internal.Target[T]{
+			Name:        factory.Name,
+			Sink:        internal.AnySink[T](factory.New(settings.pipeline, spec)),
+			Policy:      factory.Policy,
+			Concurrency: factory.Concurrency,
+		})
+	}
+	return internal.NewFanOutSink(settings.pipeline, targets...)
+}
+
+func newPostgresSink[T any](pool *pgxpool.Pool, spec internal.TableSpec, encoder internal.DBEncoder[T], settings Settings, options internal.PGOptions) internal.Sink[T] {
+	if settings.WriteMode != "" {
+		options.WriteMode = settings.WriteMode
+	}
+	if options.DeadLetters == nil {
+		options.DeadLetters = settings.DeadLetters
+	}
+	options.Pipeline = settings.pipeline
+	options.Retry.MaxAttempts = cmp.Or(settings.Retry.MaxAttempts, options.Retry.MaxAttempts)
+	options.Retry.InitialBackoff = cmp.Or(settings.Retry.InitialBackoff, options.Retry.InitialBackoff)
+	options.Retry.MaxBackoff = cmp.Or(settings.Retry.MaxBackoff, options.Retry.MaxBackoff)
+	options.History = options.History || settings.History
+	options.Lineage = options.Lineage || settings.Lineage
+	if policy, ok := settings.Sync[settings.pipeline]; ok {
+		spec.Sync = policy
+	}
+	if settings.Swap[settings.pipeline] {
+		options.Swap = true
+		options.SwapMaxShrinkPercent = settings.SwapMaxShrinkPercent
+	}
+	if settings.BulkLoad[settings.pipeline] {
+		options.BulkLoad = true
+	}
+	if len(settings.SessionParams) > 0 {
+		options.SessionParams = settings.SessionParams
+	}
+	return internal.NewPostgresRepository(pool, spec, encoder, options)
+}
+
+type Definition struct {
+	Name             string
+	Description      string
+	DefaultBatchSize int
+	DependsOn        []string
+	// Schedule is the cron expression used by serve, empty for on-demand only.
+	Schedule string
+	// ReleaseUrl returns the Receita URL whose dados_abertos_cnpj/YYYY-MM
+	// folder serve watches; the pipeline then only runs for a newer release.
+	ReleaseUrl func(settings Settings) string
+	// Table is the TableSpec the pipeline loads and SourceUrls the remote
+	// files or endpoints it reads; doctor checks both before a run.
+	Table      internal.TableSpec
+	SourceUrls func(settings Settings) []string
+	Run        func(ctx context.Context, pool *pgxpool.Pool, settings Settings) (Stats, error)
+}
+
+var Registry = []Definition{
+	{
+		Name:             "states",
+		Description:      "IBGE states (estados)",
+		DefaultBatchSize: 300,
+		Schedule:         "@weekly",
+		Table:            statesTable,
+		SourceUrls: func(settings Settings) []string {
+			return []string{settings.LocationUrl + "/estados"}
+		},
+		Run: func(ctx context.Context, pool *pgxpool.Pool, settings Settings) (Stats, error) {
+			return RunStatesPipeline(ctx, pool, fmt.Sprintf("%s/estados", settings.LocationUrl), settings.withBatchSize(300))
+		},
+	},
+	{
+		Name:             "cities",
+		Description:      "IBGE cities (municipios), requires states",
+		DefaultBatchSize: 10,
+		DependsOn:        []string{"states"},
+		Schedule:         "@weekly",
+		Table:            citiesTable,
+		SourceUrls: func(settings Settings) []string {
+			return []string{settings.LocationUrl + "/municipios"}
+		},
+		Run: func(ctx context.Context, pool *pgxpool.Pool, settings Settings) (Stats, error) {
+			return RunCitiesPipeline(ctx, pool, fmt.Sprintf("%s/municipios", settings.LocationUrl), settings.withBatchSize(10))
+		},
+	},
+	{
+		Name:             "districts",
+		Description:      "IBGE districts (distritos), requires cities",
+		DefaultBatchSize: 30,
+		DependsOn:        []string{"cities"},
+		Schedule:         "@weekly",
+		Table:            districtsTable,
+		SourceUrls: func(settings Settings) []string {
+			return []string{settings.LocationUrl + "/distritos"}
+		},
+		Run: func(ctx context.Context, pool *pgxpool.Pool, settings Settings) (Stats, error) {
+			return RunDistrictsPipeline(ctx, pool, fmt.Sprintf("%s/distritos", settings.LocationUrl), settings.withBatchSize(30))
+		},
+	},
+	{
+		Name:             "companies",
+		Description:      "Receita Federal companies (Empresas zip)",
+		DefaultBatchSize: 5000,
+		Schedule:         "@daily",
+		Table:            companiesTable,
+		ReleaseUrl: func(settings Settings) string {
+			return settings.CompanyZipUrl
+		},
+		SourceUrls: func(settings Settings) []string {
+			zipUrl, _ := settings.releaseSource(settings.CompanyZipUrl, settings.CompanyStoragePath)
+			return []string{zipUrl}
+		},
+		Run: func(ctx context.Context, pool *pgxpool.Pool, settings Settings) (Stats, error) {
+			zipUrl, storagePath := settings.releaseSource(settings.CompanyZipUrl, settings.CompanyStoragePath)
+			zipPath := filepath.Join(storagePath, "companies.zip")
+			extractPath := filepath.Join(storagePath, "extracted")
+			return RunCompaniesPipeline(ctx, pool, zipUrl, zipPath, extractPath, settings.withBatchSize(5000))
+		},
+	},
+}
+
+// Target is the table the pipeline writes with settings applied, as its sink
+// sees it, empty for pipelines without a Table.
+func (d Definition) Target(settings Settings) internal.TableSpec {
+	if d.Table.Name != "" && settings.Lineage {
+		return d.Table.WithLineageColumns()
+	}
+	return d.Table
+}
+
+func Find(defs []Definition, name string) (Definition, bool) {
+	for _, def := range defs {
+		if def.Name == name {
+			return def, true
+		}
+	}
+	return Definition{}, false
+}
@@ -0,0 +1,114 @@
+package pipelines
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+type PipelineReport struct {
+	Name    string  `json:"name"`
+	Status  Status  `json:"status"`
+	Error   string  `json:"error,omitempty"`
+	Seconds float64 `json:"seconds"`
+	Stats   Stats   `json:"stats"`
+}
+
+type Report struct {
+	StartedAt  time.Time        `json:"started_at"`
+	FinishedAt time.Time        `json:"finished_at"`
+	DryRun     bool             `json:"dry_run"`
+	Status     string           `json:"status"`
+	Pipelines  []PipelineReport `json:"pipelines"`
+}
+
+func NewReport(startedAt time.Time, dryRun bool, results []Result) Report {
+	report := Report{
+		StartedAt:  startedAt,
+		FinishedAt: time.Now(),
+		DryRun:     dryRun,
+		Status:     string(StatusSucceeded),
+		Pipelines:  make([]PipelineReport, 0, len(results)),
+	}
+	for _, r := range results {
+		pr := PipelineReport{
+			Name:    r.Name,
+			Status:  r.Status,
+			Seconds: r.Duration.Seconds(),
+			Stats:   r.Stats,
+		}
+		if r.Err != nil {
+			pr.Error = r.Err.Error()
+		}
+		if r.Status != StatusSucceeded {
+			report.Status = string(StatusFailed)
+		}
+		report.Pipelines = append(report.Pipelines, pr)
+	}
+	return report
+}
+
+func (r Report) WriteJSON(path string) error {
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error encoding report: %w", err)
+	}
+	if err := os.WriteFile(path, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("error writing report: %w", err)
+	}
+	return nil
+}
+
+func (r Report) WriteMarkdown(path string) error {
+	if err := os.WriteFile(path, []byte(r.Markdown()), 0644); err != nil {
+		return fmt.Errorf("error writing report: %w", err)
+	}
+	return nil
+}
+
+func (r Report) Markdown() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# Extraction run %s\n\n", r.StartedAt.Format(time.RFC3339))
+	fmt.Fprintf(&b, "Status: **%s**, took %s", r.Status, r.FinishedAt.Sub(r.StartedAt).Round(time.Second))
+	if r.DryRun {
+		b.WriteString(" (dry run)")
+	}
+	b.WriteString("\n\n")
+
+	b.WriteString("| pipeline | status | read | mapped | rejected | batches | inserted | updated | duplicates | removed | dead letters | downloaded | seconds |\n")
+	b.WriteString("|---|---|---:|---:|---:|---:|---:|---:|---:|---:|---:|---:|---:|\n")
+	for _, p := range r.Pipelines {
+		s := p.Stats
+		fmt.Fprintf(&b, "| %s | %s | %d | %d | %d | %d | %d | %d | %d | %d | %d | %d | %.1f |\n",
+			p.Name, p.Status, s.RowsRead, s.RowsMapped, s.RowsRejected, s.BatchesWritten, s.Inserted, s.Updated, s.Duplicates, s.Removed, s.DeadLettered, s.BytesDownloaded, p.Seconds)
+	}
+
+	for _, p := range r.Pipelines {
+		fmt.Fprintf(&b, "\n## %s\n\n", p.Name)
+		if p.Error != "" {
+			fmt.Fprintf(&b, "Error: `%s`\n\n", p.Error)
+		}
+		if len(p.Stats.Stages) > 0 {
+			b.WriteString("| stage | seconds |\n|---|---:|\n")
+			for _, stage := range p.Stats.Stages {
+				fmt.Fprintf(&b, "| %s | %.1f |\n", stage.Name, stage.Seconds)
+			}
+			b.WriteString("\n")
+		}
+		if len(p.Stats.Rejections) > 0 {
+			reasons := make([]string, 0, len(p.Stats.Rejections))
+			for reason := range p.Stats.Rejections {
+				reasons = append(reasons, reason)
+			}
+			sort.Strings(reasons)
+			b.WriteString("| rejection reason | rows |\n|---|---:|\n")
+			for _, reason := range reasons {
+				fmt.Fprintf(&b, "| %s | %d |\n", reason, p.Stats.Rejections[reason])
+			}
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,242 @@
+package pipelines
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/arko_tech_challenge/go_modules/data_extractor/internal"
+	"github.com/arko_tech_challenge/go_modules/data_extractor/internal/logging"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+var (
+	ErrRunNotFound = errors.New("run not found")
+	ErrRunConflict = errors.New("pipeline is already running")
+	ErrRunFinished = errors.New("run already finished")
+	errRunCanceled = errors.New("run canceled")
+)
+
+const RunCanceled Status = "canceled"
+
+// maxRuns bounds how many finished runs Runs keeps for GET /runs.
+const maxRuns = 100
+
+type RunInfo struct {
+	ID         string             `json:"id"`
+	Trigger    string             `json:"trigger"`
+	Status     Status             `json:"status"`
+	Error      string             `json:"error,omitempty"`
+	StartedAt  time.Time          `json:"started_at"`
+	FinishedAt *time.Time         `json:"finished_at,omitempty"`
+	Pipelines  []PipelineProgress `json:"pipelines"`
+}
+
+type trackedRun struct {
+	id        string
+	trigger   string
+	started   time.Time
+	finished  time.Time
+	scheduler *Scheduler
+	cancel    context.CancelCauseFunc
+	canceled  bool
+	results   []Result
+	done      chan struct{}
+}
+
+// Runs keeps track of the Scheduler runs started by serve, both scheduled
+// and requested through the admin API, and refuses to start a pipeline that
+// is already running.
+type Runs struct {
+	mu    sync.Mutex
+	seq   int
+	runs  map[string]*trackedRun
+	order []string
+}
+
+func NewRuns() *Runs {
+	return &Runs{runs: make(map[string]*trackedRun)}
+}
+
+// Start runs the selected pipelines in the background and returns as soon as
+// they are scheduled.
+func (r *Runs) Start(ctx context.Context, pool *pgxpool.Pool, settings Settings, selected []Definition, trigger string) (RunInfo, error) {
+	run, ctx, err := r.begin(ctx, selected, trigger)
+	if err != nil {
+		return RunInfo{}, err
+	}
+	go r.execute(ctx, pool, settings, run)
+	return r.info(run), nil
+}
+
+// Execute runs the selected pipelines and waits for them to finish.
+func (r *Runs) Execute(ctx context.Context, pool *pgxpool.Pool, settings Settings, selected []Definition, trigger string) ([]Result, error) {
+	run, ctx, err := r.begin(ctx, selected, trigger)
+	if err != nil {
+		return nil, err
+	}
+	r.execute(ctx, pool, settings, run)
+	return run.results, nil
+}
+
+func (r *Runs) begin(ctx context.Context, selected []Definition, trigger string) (*trackedRun, context.Context, error) {
+	scheduler, err := NewScheduler(selected)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var busy []string
+	for _, id := range r.order {
+		run := r.runs[id]
+		if !run.finished.IsZero() {
+			continue
+		}
+		for _, name := range scheduler.order {
+			if _, ok := run.scheduler.defs[name]; ok {
+				busy = append(busy, name)
+			}
+		}
+	}
+	if len(busy) > 0 {
+		return nil, nil, fmt.Errorf("%w: %s", ErrRunConflict, strings.Join(busy, ", "))
+	}
+
+	r.seq++
+	ctx, cancel := context.WithCancelCause(ctx)
+	run := &trackedRun{
+		id:        fmt.Sprintf("%s-%d", time.Now().Format("20060102T150405"), r.seq),
+		trigger:   trigger,
+		started:   time.Now(),
+		scheduler: scheduler,
+		cancel:    cancel,
+		done:      make(chan struct{}),
+	}
+	r.runs[run.id] = run
+	r.order = append(r.order, run.id)
+	r.prune()
+	ctx = internal.WithLineage(ctx, internal.Lineage{RunID: run.id})
+	return run, logging.With(ctx, "run_id", run.id), nil
+}
+
+func (r *Runs) execute(ctx context.Context, pool *pgxpool.Pool, settings Settings, run *trackedRun) {
+	results := run.scheduler.Run(ctx, pool, settings)
+	run.cancel(nil)
+
+	r.mu.Lock()
+	run.results = results
+	run.finished = time.Now()
+	r.mu.Unlock()
+	close(run.done)
+}
+
+// prune drops the oldest finished runs beyond maxRuns. Callers hold r.mu.
+func (r *Runs) prune() {
+	for len(r.order) > maxRuns {
+		dropped := false
+		for i, id := range r.order {
+			if !r.runs[id].finished.IsZero() {
+				delete(r.runs, id)
+				r.order = append(r.order[:i], r.order[i+1:]...)
+				dropped = true
+				break
+			}
+		}
+		if !dropped {
+			return
+		}
+	}
+}
+
+// Cancel interrupts a run. Its pipelines drain in-flight batches like on
+// SIGTERM and end as interrupted; the run itself ends as canceled.
+func (r *Runs) Cancel(id string) (RunInfo, error) {
+	r.mu.Lock()
+	run, ok := r.runs[id]
+	if !ok {
+		r.mu.Unlock()
+		return RunInfo{}, ErrRunNotFound
+	}
+	if !run.finished.IsZero() {
+		r.mu.Unlock()
+		return RunInfo{}, ErrRunFinished
+	}
+	run.canceled = true
+	r.mu.Unlock()
+
+	run.cancel(errRunCanceled)
+	return r.info(run), nil
+}
+
+func (r *Runs) Get(id string) (RunInfo, bool) {
+	r.mu.Lock()
+	run, ok := r.runs[id]
+	r.mu.Unlock()
+	if !ok {
+		return RunInfo{}, false
+	}
+	return r.info(run), true
+}
+
+// List returns the runs newest first.
+func (r *Runs) List() []RunInfo {
+	r.mu.Lock()
+	runs := make([]*trackedRun, 0, len(r.order))
+	for i := len(r.order) - 1; i >= 0; i-- {
+		runs = append(runs, r.runs[r.order[i]])
+	}
+	r.mu.Unlock()
+
+	infos := make([]RunInfo, len(runs))
+	for i, run := range runs {
+		infos[i] = r.info(run)
+	}
+	return infos
+}
+
+func (r *Runs) info(run *trackedRun) RunInfo {
+	r.mu.Lock()
+	info := RunInfo{
+		ID:        run.id,
+		Trigger:   run.trigger,
+		Status:    StatusRunning,
+		StartedAt: run.started,
+	}
+	if !run.finished.IsZero() {
+		finished := run.finished
+		info.FinishedAt = &finished
+		info.Status = StatusSucceeded
+		if err := Failures(run.results); err != nil {
+			info.Status = StatusFailed
+			info.Error = err.Error()
+		}
+	}
+	if run.canceled {
+		info.Status = RunCanceled
+	}
+	r.mu.Unlock()
+
+	info.Pipelines = run.scheduler.Progress()
+	return info
+}
+
+// Wait blocks until every run in progress has finished.
+func (r *Runs) Wait() {
+	r.mu.Lock()
+	pending := make([]chan struct{}, 0)
+	for _, run := range r.runs {
+		if run.finished.IsZero() {
+			pending = append(pending, run.done)
+		}
+	}
+	r.mu.Unlock()
+
+	for _, done := range pending {
+		<-done
+	}
+}
@@ -0,0 +1,263 @@
+package pipelines
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/arko_tech_challenge/go_modules/data_extractor/internal"
+	"github.com/arko_tech_challenge/go_modules/data_extractor/internal/logging"
+	"github.com/arko_tech_challenge/go_modules/data_extractor/internal/metrics"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type Status string
+
+const (
+	StatusSucceeded   Status = "succeeded"
+	StatusFailed      Status = "failed"
+	StatusSkipped     Status = "skipped"
+	StatusInterrupted Status = "interrupted"
+)
+
+type Result struct {
+	Name     string
+	Status   Status
+	Err      error
+	Duration time.Duration
+	Stats    Stats
+}
+
+// Scheduler runs a set of pipelines as a DAG. Dependencies that are not part
+// of the selection are assumed to be loaded already and are not waited on.
+type Scheduler struct {
+	defs     map[string]Definition
+	order    []string
+	deps     map[string][]string
+	stages   [][]string
+	progress map[string]*Progress
+}
+
+func NewScheduler(selected []Definition) (*Scheduler, error) {
+	s := &Scheduler{
+		defs:     make(map[string]Definition, len(selected)),
+		deps:     make(map[string][]string, len(selected)),
+		progress: make(map[string]*Progress, len(selected)),
+	}
+	for _, def := range selected {
+		if _, dup := s.defs[def.Name]; dup {
+			continue
+		}
+		s.defs[def.Name] = def
+		s.order = append(s.order, def.Name)
+		s.progress[def.Name] = newProgress()
+	}
+
+	for _, name := range s.order {
+		for _, dep := range s.defs[name].DependsOn {
+			if _, ok := s.defs[dep]; ok {
+				s.deps[name] = append(s.deps[name], dep)
+			}
+		}
+	}
+
+	stages, err := s.buildStages()
+	if err != nil {
+		return nil, err
+	}
+	s.stages = stages
+	return s, nil
+}
+
+func (s *Scheduler) buildStages() ([][]string, error) {
+	level := make(map[string]int, len(s.order))
+	visiting := make(map[string]bool)
+
+	var visit func(name string, path []string) (int, error)
+	visit = func(name string, path []string) (int, error) {
+		if l, ok := level[name]; ok {
+			return l, nil
+		}
+		if visiting[name] {
+			return 0, fmt.Errorf("%w: dependency cycle: %s -> %s", internal.ErrInvalidConfig, strings.Join(path, " -> "), name)
+		}
+		visiting[name] = true
+		l := 0
+		for _, dep := range s.deps[name] {
+			depLevel, err := visit(dep, append(append([]string{}, path...), name))
+			if err != nil {
+				return 0, err
+			}
+			l = max(l, depLevel+1)
+		}
+		visiting[name] = false
+		level[name] = l
+		return l, nil
+	}
+
+	var stages [][]string
+	for _, name := range s.order {
+		l, err := visit(name, nil)
+		if err != nil {
+			return nil, err
+		}
+		for len(stages) <= l {
+			stages = append(stages, nil)
+		}
+	}
+	for _, name := range s.order {
+		stages[level[name]] = append(stages[level[name]], name)
+	}
+	return stages, nil
+}
+
+func (s *Scheduler) Plan() string {
+	var b strings.Builder
+	for i, stage := range s.stages {
+		fmt.Fprintf(&b, "stage %d:\n", i+1)
+		for _, name := range stage {
+			if deps := s.deps[name]; len(deps) > 0 {
+				fmt.Fprintf(&b, "  %s (after %s)\n", name, strings.Join(deps, ", "))
+			} else {
+				fmt.Fprintf(&b, "  %s\n", name)
+			}
+		}
+	}
+	return b.String()
+}
+
+// Run starts every pipeline as soon as its dependencies succeed, so
+// independent branches load concurrently. A pipeline whose dependency failed
+// or was skipped is itself skipped.
+func (s *Scheduler) Run(ctx context.Context, pool *pgxpool.Pool, settings Settings) []Result {
+	done := make(map[string]chan struct{}, len(s.order))
+	for _, name := range s.order {
+		done[name] = make(chan struct{})
+	}
+
+	var mu sync.Mutex
+	results := make(map[string]Result, len(s.order))
+
+	var wg sync.WaitGroup
+	for _, name := range s.order {
+		wg.Add(1)
+		go func(name string) {
+			defer wg.Done()
+			defer close(done[name])
+			ctx := logging.With(ctx, "pipeline", name)
+
+			var failedDeps []string
+			for _, dep := range s.deps[name] {
+				<-done[dep]
+				mu.Lock()
+				status := results[dep].Status
+				mu.Unlock()
+				if status != StatusSucceeded {
+					failedDeps = append(failedDeps, dep)
+				}
+			}
+
+			result := Result{Name: name}
+			if len(failedDeps) > 0 {
+				result.Status = StatusSkipped
+				result.Err = fmt.Errorf("skipped because %s did not succeed", strings.Join(failedDeps, ", "))
+				slog.WarnContext(ctx, "Pipeline skipped", "reason", result.Err.Error())
+			} else if ctx.Err() != nil {
+				result.Status = StatusSkipped
+				result.Err = fmt.Errorf("skipped because the run was interrupted: %w", context.Cause(ctx))
+				slog.WarnContext(ctx, "Pipeline skipped", "reason", result.Err.Error())
+			} else {
+				slog.InfoContext(ctx, "Pipeline started")
+				start := time.Now()
+				settings := settings
+				settings.pipeline = name
+				settings.progress = s.progress[name]
+				settings.progress.start()
+				stats, err := s.defs[name].Run(ctx, pool, settings)
+				result.Stats = stats
+				result.Duration = time.Since(start)
+				var interrupted *InterruptedError
+				if errors.As(err, &interrupted) {
+					result.Status = StatusInterrupted
+					result.Err = err
+					slog.WarnContext(ctx, "Pipeline interrupted", "batches_written", interrupted.Batches, "cause", interrupted.Cause)
+				} else if err != nil {
+					result.Status = StatusFailed
+					result.Err = err
+					slog.ErrorContext(ctx, "Pipeline failed", "seconds", result.Duration.Seconds(), "error", err)
+				} else {
+					result.Status = StatusSucceeded
+					slog.InfoContext(ctx, "Pipeline succeeded", "seconds", result.Duration.Seconds(), "rows_read", stats.RowsRead, "rows_written", stats.RowsWritten, "rows_rejected", stats.RowsRejected)
+				}
+			}
+
+			s.progress[name].finish(result)
+			metrics.PipelineRuns.WithLabelValues(name, string(result.Status)).Inc()
+			if result.Status != StatusSkipped {
+				metrics.PipelineDuration.WithLabelValues(name).Set(result.Duration.Seconds())
+			}
+			if result.Status == StatusSucceeded {
+				metrics.PipelineLastSuccess.WithLabelValues(name).SetToCurrentTime()
+			}
+			mu.Lock()
+			results[name] = result
+			mu.Unlock()
+		}(name)
+	}
+	wg.Wait()
+
+	ordered := make([]Result, 0, len(s.order))
+	for _, stage := range s.stages {
+		for _, name := range stage {
+			ordered = append(ordered, results[name])
+		}
+	}
+	return ordered
+}
+
+// Progress returns the live state of every pipeline, in plan order.
+func (s *Scheduler) Progress() []PipelineProgress {
+	snapshots := make([]PipelineProgress, 0, len(s.order))
+	for _, stage := range s.stages {
+		for _, name := range stage {
+			snapshots = append(snapshots, s.progress[name].Snapshot(name))
+		}
+	}
+	return snapshots
+}
+
+func Failures(results []Result) error {
+	var errs []error
+	for _, r := range results {
+		if r.Status == StatusFailed || r.Status == StatusInterrupted {
+			errs = append(errs, fmt.Errorf("%s pipeline: %w", r.Name, r.Err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func Summary(results []Result) string {
+	var b strings.Builder
+	for _, r := range results {
+		fmt.Fprintf(&b, "%-10s %-11s %s", r.Name, r.Status, r.Duration.Round(time.Millisecond))
+		var interrupted *InterruptedError
+		if errors.As(r.Err, &interrupted) {
+			fmt.Fprintf(&b, " (interrupted at batch %d)", interrupted.Batches)
+		}
+		if r.Stats.DeadLettered > 0 {
+			fmt.Fprintf(&b, " (%d rows dead-lettered)", r.Stats.DeadLettered)
+		}
+		if r.Stats.Removed > 0 {
+			fmt.Fprintf(&b, " (%d vanished rows removed)", r.Stats.Removed)
+		}
+		if r.Stats.Duplicates > 0 {
+			fmt.Fprintf(&b, " (%d duplicate rows dropped)", r.Stats.Duplicates)
+		}
+		b.WriteString("\n")
+	}
+	return b.String()
+}
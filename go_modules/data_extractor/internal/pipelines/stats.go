@@ -0,0 +1,70 @@
+package pipelines
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+type Stage struct {
+	Name    string  `json:"name"`
+	Seconds float64 `json:"seconds"`
+}
+
+type Stats struct {
+	RowsRead        int64            `json:"rows_read"`
+	RowsMapped      int64            `json:"rows_mapped"`
+	RowsRejected    int64            `json:"rows_rejected"`
+	Rejections      map[string]int64 `json:"rejections,omitempty"`
+	BatchesWritten  int64            `json:"batches_written"`
+	RowsWritten     int64            `json:"rows_written"`
+	Inserted        int64            `json:"inserted"`
+	Updated         int64            `json:"updated"`
+	DeadLettered    int64            `json:"dead_lettered"`
+	Removed         int64            `json:"removed"`
+	Duplicates      int64            `json:"duplicates"`
+	BytesDownloaded int64            `json:"bytes_downloaded"`
+	BytesRead       int64            `json:"bytes_read"`
+	Stages          []Stage          `json:"stages"`
+}
+
+func (s *Stats) AddStage(name string, d time.Duration) {
+	s.Stages = append(s.Stages, Stage{Name: name, Seconds: d.Seconds()})
+}
+
+func (s *Stats) reject(reason string) {
+	if s.Rejections == nil {
+		s.Rejections = make(map[string]int64)
+	}
+	s.Rejections[reason]++
+	s.RowsRejected++
+}
+
+// Merge folds the stats of RunPipeline into the stats of the preparation
+// stages (download, extract, convert) that ran before it.
+func (s *Stats) Merge(o Stats) {
+	s.RowsRead += o.RowsRead
+	s.RowsMapped += o.RowsMapped
+	s.RowsRejected += o.RowsRejected
+	for reason, n := range o.Rejections {
+		if s.Rejections == nil {
+			s.Rejections = make(map[string]int64)
+		}
+		s.Rejections[reason] += n
+	}
+	s.BatchesWritten += o.BatchesWritten
+	s.RowsWritten += o.RowsWritten
+	s.Inserted += o.Inserted
+	s.Updated += o.Updated
+	s.DeadLettered += o.DeadLettered
+	s.Removed += o.Removed
+	s.Duplicates += o.Duplicates
+	s.BytesDownloaded += o.BytesDownloaded
+	s.BytesRead += o.BytesRead
+	s.Stages = append(s.Stages, o.Stages...)
+}
+
+type writeStats struct {
+	batches atomic.Int64
+	rows    atomic.Int64
+	nanos   atomic.Int64
+}
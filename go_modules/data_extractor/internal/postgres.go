@@ -2,99 +2,468 @@ package internal
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
+	"maps"
+	"slices"
 	"strings"
+	"sync/atomic"
 	"time"
 
+	"github.com/arko_tech_challenge/go_modules/data_extractor/internal/metrics"
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgxpool"
 	_ "github.com/lib/pq"
 )
 
+// ConflictMode is what the sink does with a row whose conflict key already
+// exists in the table.
 type ConflictMode string
 
 const (
-	ConflictModeUpdate ConflictMode = "update"
+	// ConflictModeFail sends a plain INSERT, so a conflict fails the batch
+	// with a unique violation. It is the default.
+	ConflictModeFail ConflictMode = "fail"
+	// ConflictModeIgnore keeps the stored row (ON CONFLICT DO NOTHING).
 	ConflictModeIgnore ConflictMode = "ignore"
+	// ConflictModeUpdate overwrites the update columns of the stored row.
+	ConflictModeUpdate ConflictMode = "update"
+	// ConflictModeUpdateChanged only updates rows whose update columns differ
+	// (IS DISTINCT FROM), so reloading the same data leaves no dead tuples.
+	ConflictModeUpdateChanged ConflictMode = "update_changed"
 )
 
+func ParseConflictMode(s string) (ConflictMode, error) {
+	switch mode := ConflictMode(s); mode {
+	case ConflictModeFail, ConflictModeIgnore, ConflictModeUpdate, ConflictModeUpdateChanged:
+		return mode, nil
+	case "":
+		return ConflictModeFail, nil
+	}
+	return "", fmt.Errorf("%w: invalid conflict mode %q, expected fail, ignore, update or update_changed", ErrInvalidConfig, s)
+}
+
 type TableSpec struct {
-	Name           string
-	Columns        []string
-	ConflictMode   ConflictMode
-	ConflictColumn string
-	UpdateColumns  []string
+	Name    string
+	Columns []string
+	// Types are the Postgres types of Columns, in the same order. They are
+	// optional unless the table is provisioned, and used to check the live
+	// schema.
+	Types []string
+	// NotNull and References are only used to provision the table: the
+	// columns created NOT NULL and the foreign keys, by column.
+	NotNull      []string
+	References   map[string]Reference
+	ConflictMode ConflictMode
+	// ConflictColumns is the key of the unique index ON CONFLICT targets, the
+	// first column when empty. An entry in parentheses, like "(lower(name))",
+	// is an index expression; rows are only deduplicated on it within a batch
+	// when they implement Identifiable.
+	ConflictColumns []string
+	// Duplicates picks the row kept when a key repeats within a batch.
+	Duplicates DuplicatePolicy
+	// UpdateColumns are the columns set on conflict by the update modes; all
+	// columns but the key columns when empty.
+	UpdateColumns []string
+	// CoalesceColumns are update columns that keep their stored value when
+	// the incoming one is NULL or empty.
+	CoalesceColumns []string
+	// Sync, when it has a mode, makes every run a full sync of the table.
+	Sync SyncPolicy
+	// History declares the history table of the table, written when
+	// PGOptions.History is set.
+	History HistorySpec
+}
+
+func (s TableSpec) conflictKey() []string {
+	if len(s.ConflictColumns) == 0 {
+		return s.Columns[:1]
+	}
+	return s.ConflictColumns
+}
+
+func isKeyExpression(key string) bool {
+	return strings.HasPrefix(key, "(")
+}
+
+// keyColumns are the plain columns of the conflict key.
+func (s TableSpec) keyColumns() []string {
+	var cols []string
+	for _, key := range s.conflictKey() {
+		if !isKeyExpression(key) {
+			cols = append(cols, key)
+		}
+	}
+	return cols
+}
+
+// keyIndexes are the positions of keyColumns in Columns.
+func (s TableSpec) keyIndexes() []int {
+	var indexes []int
+	for _, key := range s.keyColumns() {
+		if i := slices.Index(s.Columns, key); i >= 0 {
+			indexes = append(indexes, i)
+		}
+	}
+	return indexes
+}
+
+// hasConflictClause reports whether the upsert carries an ON CONFLICT clause,
+// which needs a unique index on the conflict column.
+func (s TableSpec) hasConflictClause() bool {
+	return s.ConflictMode == ConflictModeIgnore || s.updates()
+}
+
+func (s TableSpec) updates() bool {
+	return s.ConflictMode == ConflictModeUpdate || s.ConflictMode == ConflictModeUpdateChanged
+}
+
+func (s TableSpec) updateColumns() []string {
+	if len(s.UpdateColumns) > 0 {
+		return s.UpdateColumns
+	}
+	key := s.keyColumns()
+	cols := make([]string, 0, len(s.Columns))
+	for _, col := range s.Columns {
+		if !slices.Contains(key, col) {
+			cols = append(cols, col)
+		}
+	}
+	return cols
+}
+
+// newValue is the SQL expression col is updated to, given the aliases of the
+// stored and the incoming row.
+func (s TableSpec) newValue(col, current, incoming string) string {
+	if !slices.Contains(s.CoalesceColumns, col) {
+		return incoming + "." + col
+	}
+	return fmt.Sprintf("CASE WHEN %[2]s.%[1]s IS NULL OR %[2]s.%[1]s::text = '' THEN %[3]s.%[1]s ELSE %[2]s.%[1]s END", col, incoming, current)
+}
+
+// changed is the condition under which ConflictModeUpdateChanged updates a
+// stored row. Lineage columns are left out, they differ on every load.
+func (s TableSpec) changed(current, incoming string) string {
+	var stored, next []string
+	for _, col := range s.updateColumns() {
+		if isLineageColumn(col) {
+			continue
+		}
+		stored = append(stored, current+"."+col)
+		next = append(next, s.newValue(col, current, incoming))
+	}
+	return fmt.Sprintf("ROW(%s) IS DISTINCT FROM ROW(%s)", strings.Join(stored, ", "), strings.Join(next, ", "))
+}
+
+// WriteMode selects how Postgres sends a batch. WriteModeInsert binds every
+// value of the batch as a parameter of one multi-row INSERT, which is capped
+// at 65535 parameters. WriteModeCopy streams the batch with COPY into a
+// temporary staging table and upserts from there in the same transaction.
+type WriteMode string
+
+const (
+	WriteModeInsert WriteMode = "insert"
+	WriteModeCopy   WriteMode = "copy"
+)
+
+func ParseWriteMode(s string) (WriteMode, error) {
+	switch mode := WriteMode(s); mode {
+	case WriteModeInsert, WriteModeCopy:
+		return mode, nil
+	case "":
+		return WriteModeInsert, nil
+	}
+	return "", fmt.Errorf("%w: invalid write mode %q, expected insert or copy", ErrInvalidConfig, s)
 }
 
 type PGOptions struct {
 	TxTimeout time.Duration
+	WriteMode WriteMode
+	// Retry applies to transient errors only; zero fields take the
+	// RetryPolicy defaults.
+	Retry RetryPolicy
+	// DeadLetters, when set, receives the rows of a batch that fail with
+	// invalid data (SQLSTATE classes 22 and 23); the batch is bisected until
+	// they are isolated and every other row is committed. Without it such a
+	// batch fails as a whole.
+	DeadLetters DeadLetterWriter
+	// Pipeline labels the retry metric and the dead letters.
+	Pipeline string
+	// History enables the history table of the TableSpec, if it declares
+	// one. Release is the YYYY-MM release being loaded, recorded in it.
+	History bool
+	Release string
+	// Lineage stamps every row with the lineage columns, filled from the
+	// Lineage of the batch context; the table must have them.
+	Lineage bool
+	// Swap loads the table blue/green, into a shadow table swapped in by
+	// Finish, see tableSwap. It replaces the table as a whole, so a full sync
+	// is moot, and there are no previous versions for the history table.
+	// SwapMaxShrinkPercent defaults to 10.
+	Swap                 bool
+	SwapMaxShrinkPercent float64
+	// BulkLoad speeds up a large load, like the first one of companies:
+	// Begin drops the non-unique indexes of the table and disables its user
+	// triggers, and End restores them and analyzes it however the run ended.
+	// Batches use WriteModeCopy, whose staging table is temporary and so
+	// never WAL-logged, with synchronous_commit off unless SessionParams set
+	// it. A swap leaves indexes to the end already, so only the latter apply.
+	BulkLoad bool
+	// SessionParams are set, as with SET LOCAL, in every write transaction
+	// and in the one End rebuilds indexes in.
+	SessionParams map[string]string
 }
 
 func (o PGOptions) Default() PGOptions {
 	if o.TxTimeout == 0 {
 		o.TxTimeout = 10 * time.Second
 	}
+	if o.WriteMode == "" {
+		o.WriteMode = WriteModeInsert
+	}
+	o.Retry = o.Retry.Default()
 	return o
 }
 
+// maxParameters is the limit of bind parameters in one Postgres statement.
+const maxParameters = 65535
+
+// stagingTable is the temporary table WriteModeCopy loads into. It is
+// dropped when the batch transaction ends, so concurrent batches on other
+// connections never see each other's rows.
+const stagingTable = "extractor_staging"
+
 type Postgres[T any] struct {
 	pool    *pgxpool.Pool
 	spec    TableSpec
 	encoder DBEncoder[T]
 	options PGOptions
+
+	// sync is set when the spec has a SyncPolicy mode.
+	sync *fullSync
+	// swap is set with PGOptions.Swap; spec then names its shadow table.
+	swap *tableSwap
+	// bulk is set with PGOptions.BulkLoad.
+	bulk *bulkLoad
+
+	inserted     atomic.Int64
+	updated      atomic.Int64
+	deadLettered atomic.Int64
+	removed      atomic.Int64
+	duplicates   atomic.Int64
 }
 
 func NewPostgresRepository[T any](pool *pgxpool.Pool, spec TableSpec, encoder DBEncoder[T], options PGOptions) Sink[T] {
-	return &Postgres[T]{
+	if options.Lineage {
+		spec = spec.WithLineageColumns()
+	}
+	p := &Postgres[T]{
 		pool:    pool,
 		spec:    spec,
 		encoder: encoder,
 		options: options,
 	}
+	if options.BulkLoad {
+		p.options.WriteMode = WriteModeCopy
+		p.options.SessionParams = maps.Clone(options.SessionParams)
+		if p.options.SessionParams == nil {
+			p.options.SessionParams = make(map[string]string)
+		}
+		if _, ok := p.options.SessionParams["synchronous_commit"]; !ok {
+			p.options.SessionParams["synchronous_commit"] = "off"
+		}
+		if !options.Swap {
+			p.bulk = newBulkLoad(pool, spec.Name, p.options.SessionParams)
+		}
+	}
+	switch {
+	case options.Swap:
+		p.swap = newTableSwap(pool, spec.Name, options.SwapMaxShrinkPercent)
+		p.spec.Name = p.swap.shadow
+		p.options.History = false
+	case spec.Sync.Mode != "":
+		p.sync = newFullSync(pool, spec)
+	}
+	return p
 }
 
 func (p *Postgres[T]) WriteBatch(ctx context.Context, batch []T) error {
 	if len(batch) == 0 {
-		log.Printf("Skipping empty batch")
+		slog.DebugContext(ctx, "Skipping empty batch")
 		return nil
 	}
 
 	return p.upsertBatch(ctx, batch)
 }
 
-func (p *Postgres[T]) upsertBatch(ctx context.Context, batch []T) error {
-	cols := p.spec.Columns
-	nCols := len(cols)
-
-	seen := make(map[any]bool)
-	uniqueBatch := make([]T, 0, len(batch))
+// encodeBatch encodes batch, keeping one row per conflict key: a statement
+// that touches the same row twice fails with ON CONFLICT DO UPDATE.
+func (p *Postgres[T]) encodeBatch(ctx context.Context, batch []T) (*deduper, error) {
+	nCols := len(p.spec.Columns)
+	lineage, loadedAt := LineageFrom(ctx), time.Now()
+	if p.options.Lineage {
+		nCols -= len(lineageColumns)
+	}
 
+	dedup := newDeduper(p.spec, len(batch))
 	for _, v := range batch {
 		values, err := p.encoder.Encode(ctx, v)
 		if err != nil {
-			return fmt.Errorf("error encoding batch: %w", err)
+			return nil, fmt.Errorf("%w: error encoding batch: %w", ErrInvalidData, err)
 		}
 
 		if len(values) != nCols {
-			return fmt.Errorf("expected %d values, got %d", nCols, len(values))
+			return nil, fmt.Errorf("%w: expected %d values, got %d", ErrSchemaMismatch, nCols, len(values))
+		}
+		if p.options.Lineage {
+			// Capped so the encoder's slice is never appended to in place.
+			values = append(values[:nCols:nCols], lineageValues(lineage, v, loadedAt)...)
 		}
 
-		if !seen[values[0]] {
-			seen[values[0]] = true
-			uniqueBatch = append(uniqueBatch, v)
+		dedup.add(v, values)
+	}
+	return dedup, nil
+}
+
+func (p *Postgres[T]) upsertBatch(ctx context.Context, batch []T) error {
+	dedup, err := p.encodeBatch(ctx, batch)
+	if err != nil {
+		return err
+	}
+	rows := dedup.rows
+	// Keys are tracked before the write, so a dead-lettered row still counts
+	// as present in the source.
+	if p.sync != nil {
+		if err := p.sync.track(ctx, rows); err != nil {
+			return err
+		}
+	}
+	if p.swap != nil {
+		if err := p.swap.prepare(ctx); err != nil {
+			return err
 		}
 	}
+	if err := p.writeBisecting(ctx, rows, dedup.items); err != nil {
+		return err
+	}
+	p.duplicates.Add(int64(dedup.duplicates))
+	return nil
+}
+
+// writeBisecting writes rows, splitting them in halves while a half fails with
+// invalid data, until the offending rows are isolated and dead-lettered.
+func (p *Postgres[T]) writeBisecting(ctx context.Context, rows [][]any, items []any) error {
+	err := p.writeRetrying(ctx, rows)
+	if err == nil || p.options.DeadLetters == nil || !errors.Is(err, ErrInvalidData) || ctx.Err() != nil {
+		return err
+	}
+	if len(rows) == 1 {
+		return p.deadLetter(ctx, rows[0], items[0], err)
+	}
+	mid := len(rows) / 2
+	if err := p.writeBisecting(ctx, rows[:mid], items[:mid]); err != nil {
+		return err
+	}
+	return p.writeBisecting(ctx, rows[mid:], items[mid:])
+}
 
-	placeholders := make([]string, 0, len(uniqueBatch))
-	args := make([]any, 0, len(uniqueBatch)*nCols)
+func (p *Postgres[T]) deadLetter(ctx context.Context, values []any, item any, cause error) error {
+	letter := DeadLetter{
+		Time:     time.Now(),
+		Pipeline: p.options.Pipeline,
+		Table:    p.spec.Name,
+		Error:    cause.Error(),
+		Row:      make(map[string]any, len(values)),
+	}
+	var pgErr *pgconn.PgError
+	if errors.As(cause, &pgErr) {
+		letter.Error = pgErr.Message
+		letter.SQLState = pgErr.Code
+	}
+	if positioned, ok := item.(Positioned); ok {
+		pos := positioned.SourcePosition()
+		letter.File, letter.Line = pos.File, pos.Line
+	}
+	for i, col := range p.spec.Columns {
+		letter.Row[col] = values[i]
+	}
 
-	for i, v := range uniqueBatch {
-		values, err := p.encoder.Encode(ctx, v)
-		if err != nil {
-			return fmt.Errorf("error encoding batch: %w", err)
+	if err := p.options.DeadLetters.WriteDeadLetter(ctx, letter); err != nil {
+		return errors.Join(cause, err)
+	}
+	p.deadLettered.Add(1)
+	metrics.RowsRejected.WithLabelValues(p.options.Pipeline, "dead_letter").Inc()
+	slog.WarnContext(ctx, "Row dead-lettered", "table", p.spec.Name, "sqlstate", letter.SQLState, "file", letter.File, "line", letter.Line, "error", letter.Error)
+	return nil
+}
+
+// writeRetrying writes rows, retrying transient errors per options.Retry.
+func (p *Postgres[T]) writeRetrying(ctx context.Context, rows [][]any) error {
+	retry := p.options.Retry.Default()
+	for attempt := 1; ; attempt++ {
+		err := p.writeRows(ctx, rows)
+		if err == nil || attempt >= retry.MaxAttempts || !isTransient(err) || ctx.Err() != nil {
+			return err
+		}
+		wait := retry.backoff(attempt)
+		slog.WarnContext(ctx, "Retrying batch after transient error", "attempt", attempt, "backoff", wait.String(), "error", err)
+		metrics.WriteRetries.WithLabelValues(p.options.Pipeline).Inc()
+		if err := sleep(ctx, wait); err != nil {
+			return fmt.Errorf("%w: %w", ErrSinkWrite, err)
 		}
+	}
+}
+
+// writeRows writes encoded rows in one transaction.
+func (p *Postgres[T]) writeRows(ctx context.Context, rows [][]any) error {
+	ctxTx := ctx
+	if p.options.TxTimeout > 0 {
+		var cancel context.CancelFunc
+		ctxTx, cancel = context.WithTimeout(ctx, p.options.TxTimeout)
+		defer cancel()
+	}
+
+	tx, err := p.pool.BeginTx(ctxTx, pgx.TxOptions{})
+	if err != nil {
+		return fmt.Errorf("%w: error starting transaction: %w", ErrSinkWrite, err)
+	}
+	defer tx.Rollback(ctx)
+
+	if err := setLocal(ctx, tx, p.options.SessionParams); err != nil {
+		return err
+	}
+	var inserted, updated int64
+	if p.options.WriteMode == WriteModeCopy {
+		inserted, updated, err = p.copyRows(ctx, tx, rows)
+	} else {
+		inserted, updated, err = p.insertRows(ctx, tx, rows)
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("%w: error committing transaction: %w", classifyPGError(err), err)
+	}
+
+	p.inserted.Add(inserted)
+	p.updated.Add(updated)
+	return nil
+}
+
+func (p *Postgres[T]) insertRows(ctx context.Context, tx pgx.Tx, rows [][]any) (int64, int64, error) {
+	nCols := len(p.spec.Columns)
+	if len(rows)*nCols > maxParameters {
+		return 0, 0, fmt.Errorf("%w: a batch of %d rows needs %d parameters, over the Postgres limit of %d; lower the batch size or use the copy write mode",
+			ErrInvalidConfig, len(rows), len(rows)*nCols, maxParameters)
+	}
+
+	placeholders := make([]string, 0, len(rows))
+	args := make([]any, 0, len(rows)*nCols)
 
+	for i, values := range rows {
 		base := i * nCols
 		slots := make([]string, nCols)
 		for j := 0; j < nCols; j++ {
@@ -105,57 +474,144 @@ func (p *Postgres[T]) upsertBatch(ctx context.Context, batch []T) error {
 		args = append(args, values...)
 	}
 
-	var conflitClause, updateClause string
+	sql := fmt.Sprintf(
+		"INSERT INTO %s AS t (%s) VALUES %s%s",
+		p.spec.Name,
+		strings.Join(p.spec.Columns, ", "),
+		strings.Join(placeholders, ", "),
+		p.spec.onConflict(),
+	)
+	return countUpserts(tx.Query(ctx, p.returning(sql), args...))
+}
 
-	if p.spec.ConflictMode == ConflictModeUpdate && len(p.spec.UpdateColumns) > 0 {
-		conflictColumn := p.spec.ConflictColumn
-		if conflictColumn == "" {
-			conflictColumn = p.spec.Columns[0]
-		}
-		conflitClause = fmt.Sprintf(" ON CONFLICT (%s) ", conflictColumn)
-		if len(p.spec.UpdateColumns) > 0 {
-			sets := make([]string, len(p.spec.UpdateColumns))
-			for i, col := range p.spec.UpdateColumns {
-				sets[i] = fmt.Sprintf("%s = EXCLUDED.%s", col, col)
-			}
-			updateClause = " DO UPDATE SET " + strings.Join(sets, ", ")
-		} else {
-			updateClause = " DO NOTHING"
-		}
+func (p *Postgres[T]) copyRows(ctx context.Context, tx pgx.Tx, rows [][]any) (int64, int64, error) {
+	cols := strings.Join(p.spec.Columns, ", ")
+
+	// Built from the target so every staged column has the target's type.
+	create := fmt.Sprintf("CREATE TEMP TABLE %s ON COMMIT DROP AS SELECT %s FROM %s WITH NO DATA", stagingTable, cols, p.spec.Name)
+	if _, err := tx.Exec(ctx, create); err != nil {
+		return 0, 0, fmt.Errorf("%w: error creating staging table: %w", classifyPGError(err), err)
+	}
+
+	if _, err := tx.CopyFrom(ctx, pgx.Identifier{stagingTable}, p.spec.Columns, pgx.CopyFromRows(rows)); err != nil {
+		return 0, 0, fmt.Errorf("%w: error copying batch: %w", classifyPGError(err), err)
 	}
 
 	sql := fmt.Sprintf(
-		"INSERT INTO %s (%s) VALUES %s%s%s",
+		"INSERT INTO %s AS t (%s) SELECT %s FROM %s%s",
 		p.spec.Name,
-		strings.Join(cols, ", "),
-		strings.Join(placeholders, ", "),
-		conflitClause,
-		updateClause,
+		cols,
+		cols,
+		stagingTable,
+		p.spec.onConflict(),
 	)
+	return countUpserts(tx.Query(ctx, p.returning(sql)))
+}
 
-	ctxTx := ctx
-	if p.options.TxTimeout > 0 {
-		var cancel context.CancelFunc
-		ctxTx, cancel = context.WithTimeout(ctx, p.options.TxTimeout)
-		defer cancel()
+// returning completes the upsert statement insert so it returns one
+// (xmax = 0) per row, see countUpserts, and writes the history table when
+// enabled.
+func (p *Postgres[T]) returning(insert string) string {
+	if p.options.History && p.spec.History.Table != "" {
+		return p.spec.withHistory(insert, p.options.Release)
 	}
+	return insert + " RETURNING (xmax = 0)"
+}
 
-	tx, err := p.pool.BeginTx(ctxTx, pgx.TxOptions{})
-	if err != nil {
-		return fmt.Errorf("error starting transaction: %w", err)
+// onConflict is the ON CONFLICT clause of the upsert into the table aliased
+// t, empty for ConflictModeFail. Rows skipped by the clause are not returned,
+// so they count as neither inserted nor updated.
+func (s TableSpec) onConflict() string {
+	if !s.hasConflictClause() {
+		return ""
 	}
-	defer tx.Rollback(ctx)
+	if !s.updates() {
+		return fmt.Sprintf(" ON CONFLICT (%s) DO NOTHING", strings.Join(s.conflictKey(), ", "))
+	}
+	cols := s.updateColumns()
+	sets := make([]string, len(cols))
+	for i, col := range cols {
+		sets[i] = fmt.Sprintf("%s = %s", col, s.newValue(col, "t", "EXCLUDED"))
+	}
+	clause := fmt.Sprintf(" ON CONFLICT (%s) DO UPDATE SET %s", strings.Join(s.conflictKey(), ", "), strings.Join(sets, ", "))
+	if s.ConflictMode == ConflictModeUpdateChanged {
+		clause += " WHERE " + s.changed("t", "EXCLUDED")
+	}
+	return clause
+}
 
-	_, err = tx.Exec(ctx, sql, args...)
+// countUpserts reads the RETURNING (xmax = 0) of an upsert, which is true for
+// inserted rows and false for updated ones.
+func countUpserts(rows pgx.Rows, err error) (int64, int64, error) {
 	if err != nil {
-		log.Printf("Error executing query: %v", err)
-		return fmt.Errorf("error executing query: %w", err)
+		return 0, 0, fmt.Errorf("%w: error executing query: %w", classifyPGError(err), err)
 	}
+	defer rows.Close()
 
-	if err := tx.Commit(ctx); err != nil {
-		log.Printf("Error committing transaction: %v", err)
-		return fmt.Errorf("error committing transaction: %w", err)
+	var inserted, updated int64
+	for rows.Next() {
+		var isInsert bool
+		if err := rows.Scan(&isInsert); err != nil {
+			return 0, 0, fmt.Errorf("%w: error reading upsert result: %w", ErrSinkWrite, err)
+		}
+		if isInsert {
+			inserted++
+		} else {
+			updated++
+		}
 	}
+	if err := rows.Err(); err != nil {
+		return 0, 0, fmt.Errorf("%w: error executing query: %w", classifyPGError(err), err)
+	}
+	return inserted, updated, nil
+}
 
-	return nil
+// classifyPGError maps a Postgres error to one of the package error classes
+// using its SQLSTATE class: 42 (syntax, undefined table or column) is a schema
+// mismatch, 22 and 23 (data exceptions, constraint violations) are bad data.
+func classifyPGError(err error) error {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		switch pgErr.Code[:2] {
+		case "42":
+			return ErrSchemaMismatch
+		case "22", "23":
+			return ErrInvalidData
+		}
+	}
+	return ErrSinkWrite
+}
+
+// Begin sets the table up for a bulk load, if enabled.
+func (p *Postgres[T]) Begin(ctx context.Context) error {
+	if p.bulk == nil {
+		return nil
+	}
+	return p.bulk.begin(ctx)
+}
+
+// End restores the table after a bulk load, if enabled.
+func (p *Postgres[T]) End(ctx context.Context, _ error) error {
+	if p.bulk == nil {
+		return nil
+	}
+	return p.bulk.end(ctx)
+}
+
+// Finish runs the full sync of the table, or swaps it in, if any, once every
+// batch was written.
+func (p *Postgres[T]) Finish(ctx context.Context) error {
+	if p.swap != nil {
+		return p.swap.finish(ctx)
+	}
+	if p.sync == nil {
+		return nil
+	}
+	removed, err := p.sync.finish(ctx)
+	p.removed.Add(removed)
+	return err
+}
+
+func (p *Postgres[T]) WriteCounts() WriteCounts {
+	return WriteCounts{Inserted: p.inserted.Load(), Updated: p.updated.Load(), DeadLettered: p.deadLettered.Load(), Removed: p.removed.Load(), Duplicates: p.duplicates.Load()}
 }
@@ -0,0 +1,179 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Reference is the target of a foreign key.
+type Reference struct {
+	Table  string
+	Column string
+}
+
+// Provision creates what is missing for spec: the table, its columns, the
+// unique index on the conflict key and the history table, if declared.
+// Existing objects are left as they are, so it is safe on a database managed
+// by the Django migrations. Foreign keys are added by ProvisionReferences,
+// once every table exists. With apply false nothing is executed. It returns
+// the statements run, or that would be.
+func Provision(ctx context.Context, pool *pgxpool.Pool, spec TableSpec, apply bool) ([]string, error) {
+	if len(spec.Types) != len(spec.Columns) || slices.Contains(spec.Types, "") {
+		return nil, fmt.Errorf("%w: table %s needs a type for every column to be provisioned", ErrInvalidConfig, spec.Name)
+	}
+
+	var exists bool
+	if err := pool.QueryRow(ctx, "SELECT to_regclass($1) IS NOT NULL", spec.Name).Scan(&exists); err != nil {
+		return nil, fmt.Errorf("%w: %w", classifyPGError(err), err)
+	}
+
+	var statements []string
+	if !exists {
+		defs := make([]string, len(spec.Columns))
+		for i := range spec.Columns {
+			defs[i] = spec.columnDefinition(i)
+		}
+		statements = append(statements, fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (\n\t%s\n)", spec.Name, strings.Join(defs, ",\n\t")))
+	} else {
+		live, err := liveColumns(ctx, pool, spec)
+		if err != nil {
+			return nil, err
+		}
+		for i, col := range spec.Columns {
+			if !live[col] {
+				statements = append(statements, fmt.Sprintf("ALTER TABLE %s ADD COLUMN IF NOT EXISTS %s", spec.Name, spec.columnDefinition(i)))
+			}
+		}
+	}
+
+	if len(spec.ConflictColumns) > 0 || spec.hasConflictClause() {
+		found := false
+		if exists {
+			var err error
+			if found, err = hasUniqueIndex(ctx, pool, spec); err != nil {
+				return nil, err
+			}
+		}
+		if !found {
+			statements = append(statements, fmt.Sprintf("CREATE UNIQUE INDEX IF NOT EXISTS %s ON %s (%s)",
+				spec.keyIndexName(), spec.Name, strings.Join(spec.conflictKey(), ", ")))
+		}
+	}
+
+	if history, ok := spec.HistoryTable(); ok {
+		more, err := Provision(ctx, pool, history, false)
+		if err != nil {
+			return nil, err
+		}
+		statements = append(statements, more...)
+
+		// Versions are looked up by key to chain valid_from.
+		index := history.objectName(strings.Join(spec.keyColumns(), "_") + "_idx")
+		qualified := index
+		if i := strings.LastIndex(history.Name, "."); i >= 0 {
+			qualified = history.Name[:i+1] + index
+		}
+		var found bool
+		if err := pool.QueryRow(ctx, "SELECT to_regclass($1) IS NOT NULL", qualified).Scan(&found); err != nil {
+			return nil, fmt.Errorf("%w: %w", classifyPGError(err), err)
+		}
+		if !found {
+			statements = append(statements, fmt.Sprintf("CREATE INDEX IF NOT EXISTS %s ON %s (%s, valid_to)",
+				index, history.Name, strings.Join(spec.keyColumns(), ", ")))
+		}
+	}
+
+	return statements, execAll(ctx, pool, statements, apply)
+}
+
+// ProvisionReferences adds the foreign keys of spec that are missing, see
+// Provision.
+func ProvisionReferences(ctx context.Context, pool *pgxpool.Pool, spec TableSpec, apply bool) ([]string, error) {
+	var statements []string
+	for _, col := range spec.Columns {
+		ref, ok := spec.References[col]
+		if !ok {
+			continue
+		}
+		var found bool
+		err := pool.QueryRow(ctx, `
+			SELECT EXISTS (
+				SELECT 1 FROM pg_constraint c
+				JOIN pg_attribute a ON a.attrelid = c.conrelid AND a.attnum = c.conkey[1]
+				WHERE c.contype = 'f' AND c.conrelid = to_regclass($1) AND cardinality(c.conkey) = 1 AND a.attname = $2
+			)`, spec.Name, col).Scan(&found)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", classifyPGError(err), err)
+		}
+		if !found {
+			statements = append(statements, fmt.Sprintf("ALTER TABLE %s ADD CONSTRAINT %s FOREIGN KEY (%s) REFERENCES %s (%s)",
+				spec.Name, spec.objectName(col+"_fkey"), col, ref.Table, ref.Column))
+		}
+	}
+	return statements, execAll(ctx, pool, statements, apply)
+}
+
+func (s TableSpec) columnDefinition(i int) string {
+	def := s.Columns[i] + " " + s.Types[i]
+	if slices.Contains(s.NotNull, s.Columns[i]) {
+		def += " NOT NULL"
+	}
+	return def
+}
+
+// objectName names an index or constraint after its table, like Postgres
+// does for inline constraints.
+func (s TableSpec) objectName(suffix string) string {
+	table := s.Name
+	if i := strings.LastIndex(table, "."); i >= 0 {
+		table = table[i+1:]
+	}
+	return table + "_" + suffix
+}
+
+func (s TableSpec) keyIndexName() string {
+	cols := s.keyColumns()
+	if len(cols) < len(s.conflictKey()) {
+		cols = append(cols, "expr")
+	}
+	return s.objectName(strings.Join(cols, "_") + "_key")
+}
+
+func liveColumns(ctx context.Context, pool *pgxpool.Pool, spec TableSpec) (map[string]bool, error) {
+	rows, err := pool.Query(ctx, `
+		SELECT attname FROM pg_attribute
+		WHERE attrelid = $1::regclass AND attnum > 0 AND NOT attisdropped`, spec.Name)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", classifyPGError(err), err)
+	}
+	defer rows.Close()
+
+	live := make(map[string]bool)
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrSinkWrite, err)
+		}
+		live[name] = true
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%w: %w", classifyPGError(err), err)
+	}
+	return live, nil
+}
+
+func execAll(ctx context.Context, pool *pgxpool.Pool, statements []string, apply bool) error {
+	if !apply {
+		return nil
+	}
+	for _, statement := range statements {
+		if _, err := pool.Exec(ctx, statement); err != nil {
+			return fmt.Errorf("%w: %s: %w", classifyPGError(err), statement, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,74 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+)
+
+// Receita publishes each monthly dump under .../dados_abertos_cnpj/YYYY-MM/,
+// so the release of a file is the YYYY-MM path segment of its URL.
+var (
+	releaseSegment = regexp.MustCompile(`/(\d{4}-\d{2})/`)
+	releaseLink    = regexp.MustCompile(`href="(?:[^"]*/)?(\d{4}-\d{2})/?"`)
+)
+
+func ReleaseOf(url string) string {
+	m := releaseSegment.FindStringSubmatch(url)
+	if m == nil {
+		return ""
+	}
+	return m[1]
+}
+
+// WithRelease points a release URL at another release. URLs without a
+// YYYY-MM segment are returned unchanged.
+func WithRelease(url, release string) string {
+	loc := releaseSegment.FindStringSubmatchIndex(url)
+	if loc == nil || release == "" {
+		return url
+	}
+	return url[:loc[2]] + release + url[loc[3]:]
+}
+
+// LatestRelease lists the directory that holds the release folders of url
+// and returns the newest YYYY-MM found there.
+func LatestRelease(ctx context.Context, url string) (string, error) {
+	loc := releaseSegment.FindStringIndex(url)
+	if loc == nil {
+		return "", fmt.Errorf("%w: %s has no YYYY-MM release segment", ErrInvalidConfig, url)
+	}
+	indexUrl := url[:loc[0]+1]
+
+	req, err := http.NewRequestWithContext(ctx, "GET", indexUrl, nil)
+	if err != nil {
+		return "", fmt.Errorf("%w: %w", ErrInvalidConfig, err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("%w: %w", ErrSourceUnavailable, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return "", fmt.Errorf("%w: GET %s returned %s", ErrSourceUnavailable, indexUrl, resp.Status)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("%w: error reading %s: %w", ErrSourceUnavailable, indexUrl, err)
+	}
+
+	latest := ""
+	for _, m := range releaseLink.FindAllStringSubmatch(string(body), -1) {
+		// YYYY-MM sorts lexically.
+		if m[1] > latest {
+			latest = m[1]
+		}
+	}
+	if latest == "" {
+		return "", fmt.Errorf("%w: no release folder listed at %s", ErrSourceUnavailable, indexUrl)
+	}
+	return latest, nil
+}
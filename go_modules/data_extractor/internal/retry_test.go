@@ -0,0 +1,90 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+func TestIsTransient(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"serialization failure", &pgconn.PgError{Code: "40001"}, true},
+		{"deadlock", &pgconn.PgError{Code: "40P01"}, true},
+		{"lock not available", &pgconn.PgError{Code: "55P03"}, true},
+		{"too many connections", &pgconn.PgError{Code: "53300"}, true},
+		{"admin shutdown", &pgconn.PgError{Code: "57P01"}, true},
+		{"crash shutdown", &pgconn.PgError{Code: "57P02"}, true},
+		{"cannot connect now", &pgconn.PgError{Code: "57P03"}, true},
+		{"connection exception", &pgconn.PgError{Code: "08000"}, true},
+		{"connection failure", &pgconn.PgError{Code: "08006"}, true},
+		{"wrapped deadlock", fmt.Errorf("%w: batch: %w", ErrSinkWrite, &pgconn.PgError{Code: "40P01"}), true},
+		{"value too long", &pgconn.PgError{Code: "22001"}, false},
+		{"unique violation", &pgconn.PgError{Code: "23505"}, false},
+		{"query canceled", &pgconn.PgError{Code: "57014"}, false},
+		{"no code", &pgconn.PgError{}, false},
+		{"unexpected EOF", fmt.Errorf("reading: %w", io.ErrUnexpectedEOF), true},
+		{"connection reset", syscall.ECONNRESET, true},
+		{"connection refused", syscall.ECONNREFUSED, true},
+		{"invalid data", ErrInvalidData, false},
+		{"canceled", context.Canceled, false},
+	}
+	for _, tt := range tests {
+		if got := isTransient(tt.err); got != tt.want {
+			t.Errorf("isTransient(%s) = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	policy := RetryPolicy{InitialBackoff: 100 * time.Millisecond, MaxBackoff: time.Second}
+	tests := []struct {
+		attempt int
+		ceiling time.Duration
+	}{
+		{1, 100 * time.Millisecond},
+		{2, 200 * time.Millisecond},
+		{3, 400 * time.Millisecond},
+		{4, 800 * time.Millisecond},
+		{5, time.Second},
+		// Shifts that overflow or go past 32 stay at MaxBackoff.
+		{40, time.Second},
+		{100, time.Second},
+	}
+	for _, tt := range tests {
+		for range 1000 {
+			if got := policy.backoff(tt.attempt); got <= 0 || got > tt.ceiling {
+				t.Fatalf("backoff(%d) = %s, want in (0, %s]", tt.attempt, got, tt.ceiling)
+			}
+		}
+	}
+}
+
+func TestRetryPolicyDefault(t *testing.T) {
+	got := RetryPolicy{}.Default()
+	want := RetryPolicy{MaxAttempts: 5, InitialBackoff: 200 * time.Millisecond, MaxBackoff: 10 * time.Second}
+	if got != want {
+		t.Errorf("RetryPolicy{}.Default() = %+v, want %+v", got, want)
+	}
+	custom := RetryPolicy{MaxAttempts: 1, InitialBackoff: time.Second, MaxBackoff: time.Minute}
+	if got := custom.Default(); got != custom {
+		t.Errorf("Default() = %+v, want %+v unchanged", got, custom)
+	}
+}
+
+func TestSleepCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := sleep(ctx, time.Hour); !errors.Is(err, context.Canceled) {
+		t.Errorf("sleep = %v, want context.Canceled", err)
+	}
+}
@@ -0,0 +1,285 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Suffixes of the shadow table a swap loads and of the table it replaced,
+// kept for one Rollback. Their indexes carry the same suffixes.
+const (
+	shadowSuffix = "_next"
+	oldSuffix    = "_old"
+)
+
+// tableSwap loads a table blue/green: rows go to <table>_next, created empty
+// by the first batch with the columns, constraints and unique indexes of the
+// live table, so readers of the live table never see a half loaded one. When
+// the run is over the other indexes are built, the row count is checked and
+// the two tables are swapped by renaming them in one transaction.
+type tableSwap struct {
+	pool   *pgxpool.Pool
+	live   string
+	shadow string
+	// maxShrinkPercent fails the swap when the shadow table has that much
+	// fewer rows than the live one.
+	maxShrinkPercent float64
+
+	mu       sync.Mutex
+	prepared bool
+}
+
+func newTableSwap(pool *pgxpool.Pool, table string, maxShrinkPercent float64) *tableSwap {
+	if maxShrinkPercent <= 0 {
+		maxShrinkPercent = 10
+	}
+	return &tableSwap{pool: pool, live: table, shadow: table + shadowSuffix, maxShrinkPercent: maxShrinkPercent}
+}
+
+type liveIndex struct {
+	name       string
+	def        string
+	unique     bool
+	constraint string
+}
+
+// prepare recreates the shadow table.
+func (s *tableSwap) prepare(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.prepared {
+		return nil
+	}
+
+	// Renaming the live table would leave foreign keys pointing at it on
+	// the old one.
+	var referenced bool
+	err := s.pool.QueryRow(ctx, "SELECT EXISTS (SELECT 1 FROM pg_constraint WHERE contype = 'f' AND confrelid = $1::regclass)", s.live).Scan(&referenced)
+	if err != nil {
+		return fmt.Errorf("%w: %w", classifyPGError(err), err)
+	}
+	if referenced {
+		return fmt.Errorf("%w: %s is referenced by foreign keys and cannot be swapped", ErrSchemaMismatch, s.live)
+	}
+
+	indexes, err := s.indexes(ctx)
+	if err != nil {
+		return err
+	}
+	statements := []string{
+		"DROP TABLE IF EXISTS " + s.shadow,
+		fmt.Sprintf("CREATE TABLE %s (LIKE %s INCLUDING DEFAULTS INCLUDING CONSTRAINTS INCLUDING GENERATED INCLUDING IDENTITY INCLUDING STORAGE)", s.shadow, s.live),
+	}
+	// Unique indexes are needed up front, by ON CONFLICT.
+	for _, index := range indexes {
+		if index.unique {
+			statements = append(statements, s.shadowIndex(index)...)
+		}
+	}
+	if err := execAll(ctx, s.pool, statements, true); err != nil {
+		return err
+	}
+	s.prepared = true
+	return nil
+}
+
+// finish builds the remaining indexes and foreign keys of the shadow table,
+// validates it and swaps it in.
+func (s *tableSwap) finish(ctx context.Context) error {
+	if err := s.prepare(ctx); err != nil {
+		return err
+	}
+
+	indexes, err := s.indexes(ctx)
+	if err != nil {
+		return err
+	}
+	var statements []string
+	for _, index := range indexes {
+		if !index.unique {
+			statements = append(statements, s.shadowIndex(index)...)
+		}
+	}
+	rows, err := s.pool.Query(ctx, "SELECT conname, pg_get_constraintdef(oid) FROM pg_constraint WHERE contype = 'f' AND conrelid = $1::regclass", s.live)
+	if err != nil {
+		return fmt.Errorf("%w: %w", classifyPGError(err), err)
+	}
+	var name, def string
+	_, err = pgx.ForEachRow(rows, []any{&name, &def}, func() error {
+		statements = append(statements, fmt.Sprintf("ALTER TABLE %s ADD CONSTRAINT %s %s", s.shadow, pgx.Identifier{name}.Sanitize(), def))
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("%w: %w", classifyPGError(err), err)
+	}
+	statements = append(statements, "ANALYZE "+s.shadow)
+	if err := execAll(ctx, s.pool, statements, true); err != nil {
+		return err
+	}
+
+	var loaded, current int64
+	if err := s.pool.QueryRow(ctx, fmt.Sprintf("SELECT (SELECT count(*) FROM %s), (SELECT count(*) FROM %s)", s.shadow, s.live)).Scan(&loaded, &current); err != nil {
+		return fmt.Errorf("%w: %w", classifyPGError(err), err)
+	}
+	if loaded == 0 || float64(loaded) < float64(current)*(1-s.maxShrinkPercent/100) {
+		return fmt.Errorf("%w: %s has %d rows against %d in %s, over the %.1f%% shrink limit; the live table was kept",
+			ErrInvalidData, s.shadow, loaded, current, s.live, s.maxShrinkPercent)
+	}
+
+	err = exchange(ctx, s.pool, s.live, func(ctx context.Context, tx pgx.Tx) error {
+		if _, err := tx.Exec(ctx, "DROP TABLE IF EXISTS "+s.live+oldSuffix); err != nil {
+			return err
+		}
+		if err := renameWithIndexes(ctx, tx, s.live, "", oldSuffix); err != nil {
+			return err
+		}
+		return renameWithIndexes(ctx, tx, s.shadow, shadowSuffix, "")
+	})
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.prepared = false
+	s.mu.Unlock()
+	slog.InfoContext(ctx, "Swapped in the loaded table", "table", s.live, "rows", loaded, "previous_rows", current, "kept_as", s.live+oldSuffix)
+	return nil
+}
+
+func (s *tableSwap) indexes(ctx context.Context) ([]liveIndex, error) {
+	rows, err := s.pool.Query(ctx, `
+		SELECT c.relname, pg_get_indexdef(i.indexrelid), i.indisunique, COALESCE(con.contype::text, '')
+		FROM pg_index i
+		JOIN pg_class c ON c.oid = i.indexrelid
+		LEFT JOIN pg_constraint con ON con.conindid = i.indexrelid AND con.contype IN ('p', 'u')
+		WHERE i.indrelid = $1::regclass
+		ORDER BY c.relname`, s.live)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", classifyPGError(err), err)
+	}
+	var indexes []liveIndex
+	var index liveIndex
+	_, err = pgx.ForEachRow(rows, []any{&index.name, &index.def, &index.unique, &index.constraint}, func() error {
+		indexes = append(indexes, index)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", classifyPGError(err), err)
+	}
+	return indexes, nil
+}
+
+var indexTarget = regexp.MustCompile(`^(CREATE (?:UNIQUE )?INDEX) \S+ ON (?:ONLY )?\S+ `)
+
+// shadowIndex are the statements that recreate index on the shadow table,
+// under the name of index with shadowSuffix, along with its constraint.
+func (s *tableSwap) shadowIndex(index liveIndex) []string {
+	name := pgx.Identifier{suffixed(index.name, shadowSuffix)}.Sanitize()
+	create := indexTarget.FindStringSubmatch(index.def)[1]
+	statements := []string{fmt.Sprintf("%s %s ON %s %s", create, name, s.shadow, indexTarget.ReplaceAllLiteralString(index.def, ""))}
+	switch index.constraint {
+	case "p":
+		statements = append(statements, fmt.Sprintf("ALTER TABLE %s ADD CONSTRAINT %s PRIMARY KEY USING INDEX %s", s.shadow, name, name))
+	case "u":
+		statements = append(statements, fmt.Sprintf("ALTER TABLE %s ADD CONSTRAINT %s UNIQUE USING INDEX %s", s.shadow, name, name))
+	}
+	return statements
+}
+
+// Rollback swaps back the table a swap replaced, <table>_old, which then
+// takes the place of the old one for the next Rollback.
+func Rollback(ctx context.Context, pool *pgxpool.Pool, table string) error {
+	var exists bool
+	if err := pool.QueryRow(ctx, "SELECT to_regclass($1) IS NOT NULL", table+oldSuffix).Scan(&exists); err != nil {
+		return fmt.Errorf("%w: %w", classifyPGError(err), err)
+	}
+	if !exists {
+		return fmt.Errorf("%w: %s does not exist, there is nothing to roll back to", ErrSchemaMismatch, table+oldSuffix)
+	}
+	err := exchange(ctx, pool, table, func(ctx context.Context, tx pgx.Tx) error {
+		if _, err := tx.Exec(ctx, "DROP TABLE IF EXISTS "+table+shadowSuffix); err != nil {
+			return err
+		}
+		if err := renameWithIndexes(ctx, tx, table, "", shadowSuffix); err != nil {
+			return err
+		}
+		if err := renameWithIndexes(ctx, tx, table+oldSuffix, oldSuffix, ""); err != nil {
+			return err
+		}
+		return renameWithIndexes(ctx, tx, table+shadowSuffix, shadowSuffix, oldSuffix)
+	})
+	if err != nil {
+		return err
+	}
+	slog.InfoContext(ctx, "Rolled back to the previous table", "table", table, "kept_as", table+oldSuffix)
+	return nil
+}
+
+// exchange runs renames in a transaction holding an exclusive lock on the
+// live table, so queries wait for the swap instead of failing.
+func exchange(ctx context.Context, pool *pgxpool.Pool, live string, renames func(context.Context, pgx.Tx) error) error {
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("%w: error starting transaction: %w", classifyPGError(err), err)
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx, "LOCK TABLE "+live+" IN ACCESS EXCLUSIVE MODE"); err != nil {
+		return fmt.Errorf("%w: error locking %s: %w", classifyPGError(err), live, err)
+	}
+	if err := renames(ctx, tx); err != nil {
+		return fmt.Errorf("%w: error swapping %s: %w", classifyPGError(err), live, err)
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("%w: error committing swap of %s: %w", classifyPGError(err), live, err)
+	}
+	return nil
+}
+
+// renameWithIndexes renames table, whose name ends with from, and each of
+// its indexes to end with to instead. Renaming an index renames the
+// constraint it backs.
+func renameWithIndexes(ctx context.Context, tx pgx.Tx, table, from, to string) error {
+	rows, err := tx.Query(ctx, `
+		SELECT c.relname FROM pg_index i JOIN pg_class c ON c.oid = i.indexrelid
+		WHERE i.indrelid = $1::regclass`, table)
+	if err != nil {
+		return err
+	}
+	names, err := pgx.CollectRows(rows, pgx.RowTo[string])
+	if err != nil {
+		return err
+	}
+
+	schema := ""
+	if i := strings.LastIndex(table, "."); i >= 0 {
+		schema = table[:i+1]
+	}
+	for _, name := range names {
+		renamed := suffixed(strings.TrimSuffix(name, from), to)
+		_, err := tx.Exec(ctx, fmt.Sprintf("ALTER INDEX %s%s RENAME TO %s", schema, pgx.Identifier{name}.Sanitize(), pgx.Identifier{renamed}.Sanitize()))
+		if err != nil {
+			return err
+		}
+	}
+	base := strings.TrimSuffix(table[len(schema):], from)
+	_, err = tx.Exec(ctx, fmt.Sprintf("ALTER TABLE %s RENAME TO %s", table, suffixed(base, to)))
+	return err
+}
+
+// suffixed appends suffix to name, truncating name so the result fits the
+// 63 bytes of a Postgres identifier.
+func suffixed(name, suffix string) string {
+	const maxIdentifier = 63
+	if len(name)+len(suffix) > maxIdentifier {
+		name = name[:maxIdentifier-len(suffix)]
+	}
+	return name + suffix
+}